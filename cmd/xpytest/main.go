@@ -4,11 +4,20 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"google.golang.org/grpc"
+
 	xpytest_proto "github.com/chainer/xpytest/proto"
 
+	"github.com/chainer/xpytest/pkg/farm"
+	"github.com/chainer/xpytest/pkg/importgraph"
 	"github.com/chainer/xpytest/pkg/pytest"
 	"github.com/chainer/xpytest/pkg/reporter"
 	"github.com/chainer/xpytest/pkg/xpytest"
@@ -17,24 +26,355 @@ import (
 var python = flag.String("python", "python3", "python command")
 var markerExpression = flag.String("m", "not slow", "pytest marker expression")
 var retry = flag.Int("retry", 2, "number of retries")
+var retryFailedCases = flag.Bool("retry_failed_cases", false,
+	"retry only failed test cases instead of whole test files")
+var deferRetries = flag.Bool("defer_retries", false,
+	"retry failed tests after all tests run once instead of right away")
+var rerunConcurrency = flag.Int("rerun_concurrency", 1, "number of tests "+
+	"retried concurrently with --defer_retries (0 for unlimited)")
+var rerunWithoutXdist = flag.Bool("rerun_without_xdist", false,
+	"retry tests without pytest-xdist with --defer_retries")
+var repeat = flag.Int(
+	"repeat", 1, "number of times to run each test to find flaky tests")
+var untilFailure = flag.Bool("until_failure", false,
+	"stop repeating a test once it fails with --repeat")
+var shuffle = flag.Bool(
+	"shuffle", false, "run tests in a random order (see also --seed)")
+var seed = flag.Int64("seed", 0,
+	"seed of the random order for --shuffle (0 for a random seed)")
+var randomlySeed = flag.Bool("randomly_seed", false,
+	"pass the seed to pytest-randomly through --randomly-seed")
+var watch = flag.Bool("watch", false,
+	"keep running and rerun tests affected by changes of files")
+var watchDirs = flag.String("watch_dirs", "", "comma-separated directories "+
+	"to watch in addition to those of test file patterns")
+var watchInterval = flag.Duration(
+	"watch_interval", time.Second, "interval to check changes of files")
+var changedSince = flag.String("changed_since", "", "run only tests "+
+	"affected by files changed since this git reference (e.g., origin/master)")
+var importGraph = flag.Bool("import_graph", false, "find tests affected "+
+	"by changed files with imports of Python files (e.g., for --changed_since)")
 var credential = flag.String(
 	"credential", "", "JSON credential file for Google")
 var spreadsheetID = flag.String("spreadsheet_id", "", "spreadsheet ID to edit")
 var hint = flag.String("hint", "", "hint file")
+var history = flag.String(
+	"history", "", "history file to record durations of tests")
 var bucket = flag.Int("bucket", 1, "number of buckets")
 var thread = flag.Int("thread", 0, "number of threads per bucket")
+var buckets = flag.String("buckets", "", "comma-separated devices with "+
+	"their numbers of threads, and optionally memory in MB and device units "+
+	"(e.g., 2:8,3:4:16000:2), overriding --bucket/--thread")
+var memoryMB = flag.Int("memory_mb", 0,
+	"memory in MB per bucket for tests (0 for unlimited)")
+var deviceUnits = flag.Int("device_units", 0,
+	"number of device units per bucket for tests (0 for unlimited)")
 var reportName = flag.String("report_name", "", "name for reporter")
+var shardIndex = flag.Int("shard_index", 0, "index of the shard to run")
+var shardCount = flag.Int("shard_count", 1, "number of shards")
+var chunkThreshold = flag.Duration("chunk_threshold", 0,
+	"split tests expected to take longer than this into chunks")
+var failFast = flag.Bool(
+	"fail_fast", false, "abort execution when a test fails")
+var maxFailures = flag.Int(
+	"max_failures", 0, "abort execution when this number of tests fail")
+var totalDeadline = flag.Duration(
+	"total_deadline", 0, "time budget of the whole execution")
+var gracePeriod = flag.Duration("grace_period", 30*time.Second,
+	"duration before the time budget runs out, in which no tests start")
+var idleTimeout = flag.Duration("idle_timeout", 0, "terminate a test as "+
+	"HUNG if it prints nothing for this duration (0 for no idle timeout)")
+var memoryLimitMB = flag.Int("memory_limit_mb", 0, "default limit of the "+
+	"address space in MB of each process of a test (0 for no limit)")
+var cpuTimeLimit = flag.Duration("cpu_time_limit", 0, "default limit of "+
+	"CPU time of each process of a test (0 for no limit)")
+var killGracePeriod = flag.Duration("kill_grace_period",
+	pytest.DefaultKillGracePeriod, "duration to wait for processes of a test "+
+		"to exit after SIGTERM before sending SIGKILL on its deadline")
+var coordinator = flag.String("coordinator", "",
+	"address to listen on as a coordinator of workers (e.g., :8080)")
+var worker = flag.String("worker", "",
+	"address of a coordinator to run tests as a worker")
+var workerSlots = flag.Int(
+	"worker_slots", 1, "number of tests that a worker runs concurrently")
+var leaseTimeout = flag.Duration("lease_timeout", time.Minute,
+	"duration to wait for a silent worker before leasing its tests again")
+
+// handleSignals returns a context that is cancelled when xpytest receives
+// SIGINT or SIGTERM, so that running tests stop and a summary is printed.  A
+// second signal terminates xpytest immediately.  The returned function returns
+// the received signal, or nil if no signal has been received.
+func handleSignals(ctx context.Context) (context.Context, func() os.Signal) {
+	ctx, cancel := context.WithCancel(ctx)
+	var received os.Signal
+	mutex := sync.Mutex{}
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sigChan
+		fmt.Fprintf(os.Stderr, "[INFO] received %s, stopping tests "+
+			"(send it again to exit immediately)...\n", s)
+		mutex.Lock()
+		received = s
+		mutex.Unlock()
+		cancel()
+		s = <-sigChan
+		os.Exit(exitCodeForSignal(s))
+	}()
+	return ctx, func() os.Signal {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return received
+	}
+}
+
+// exitCodeForSignal returns an exit code for a process interrupted by the
+// signal, following the convention of shells.
+func exitCodeForSignal(s os.Signal) int {
+	if s, ok := s.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 130
+}
+
+func runWorker(ctx context.Context, base *pytest.Pytest) {
+	conn, err := grpc.Dial(*worker, grpc.WithInsecure())
+	if err != nil {
+		panic(fmt.Sprintf("failed to connect to coordinator: %s", err))
+	}
+	defer conn.Close()
+	hostname, err := os.Hostname()
+	if err != nil {
+		panic(fmt.Sprintf("failed to get hostname: %s", err))
+	}
+	w := farm.NewWorker(
+		fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+		xpytest_proto.NewFarmClient(conn), base)
+	w.Slots = *workerSlots
+	if err := w.Run(ctx); err != nil && ctx.Err() == nil {
+		panic(fmt.Sprintf("failed to run worker: %s", err))
+	}
+}
+
+func executeWithCoordinator(
+	ctx context.Context, xt *xpytest.Xpytest, r reporter.Reporter,
+) {
+	lis, err := net.Listen("tcp", *coordinator)
+	if err != nil {
+		panic(fmt.Sprintf("failed to listen: %s", err))
+	}
+	c := farm.NewCoordinator()
+	c.LeaseTimeout = *leaseTimeout
+	s := grpc.NewServer()
+	xpytest_proto.RegisterFarmServer(s, c)
+	go s.Serve(lis)
+	defer s.GracefulStop()
+
+	if err := xt.ExecuteWithCoordinator(ctx, c, r); err != nil {
+		panic(fmt.Sprintf("failed to execute: %s", err))
+	}
+	drainCtx, cancel := context.WithTimeout(
+		context.Background(), *leaseTimeout)
+	defer cancel()
+	if err := c.Drain(drainCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] failed to stop workers: %s\n", err)
+	}
+}
+
+// newXpytest creates an Xpytest with the tests given by command-line arguments
+// and applies flags, the hint and the history to it.  If changed is not nil,
+// only tests affected by the changed files are kept.
+func newXpytest(
+	base *pytest.Pytest, changed []string,
+) (*xpytest.Xpytest, *xpytest_proto.HistoryFile) {
+	xt := xpytest.NewXpytest(base)
+	xt.ChunkThreshold = *chunkThreshold
+	xt.MaxFailures = *maxFailures
+	if *failFast {
+		xt.MaxFailures = 1
+	}
+	xt.TotalDeadline = *totalDeadline
+	xt.GracePeriod = *gracePeriod
+	xt.BucketMemoryMB = *memoryMB
+	xt.BucketDeviceUnits = *deviceUnits
+	xt.DeferRetries = *deferRetries
+	xt.RerunConcurrency = *rerunConcurrency
+	xt.RerunWithoutXdist = *rerunWithoutXdist
+	xt.Repeat = *repeat
+	xt.UntilFailure = *untilFailure
+	xt.Shuffle = *shuffle
+	xt.Seed = *seed
+	xt.PassSeed = *randomlySeed
+	if *buckets != "" {
+		b, err := xpytest.ParseBuckets(*buckets)
+		if err != nil {
+			panic(fmt.Sprintf("failed to parse buckets: %s", err))
+		}
+		xt.Buckets = b
+	}
+
+	for _, arg := range flag.Args() {
+		if err := xt.AddTestsWithFilePattern(arg); err != nil {
+			panic(fmt.Sprintf("failed to add tests: %s", err))
+		}
+	}
+
+	if *hint != "" {
+		if h, err := xpytest.LoadHintFile(*hint); err != nil {
+			panic(fmt.Sprintf(
+				"failed to read hint information from file: %s: %s",
+				*hint, err))
+		} else if err := xt.ApplyHint(h); err != nil {
+			panic(fmt.Sprintf("failed to apply hint: %s", err))
+		}
+	}
+
+	if changed != nil {
+		if *importGraph {
+			xt.ImportGraph = buildImportGraph()
+		}
+		xt.SelectAffectedTests(changed)
+	}
+
+	var h *xpytest_proto.HistoryFile
+	if *history != "" {
+		var err error
+		if h, err = xpytest.LoadHistoryFile(*history); err != nil {
+			panic(fmt.Sprintf(
+				"failed to read history from file: %s: %s", *history, err))
+		} else if err := xt.ApplyHistory(h); err != nil {
+			panic(fmt.Sprintf("failed to apply history: %s", err))
+		}
+	}
+
+	if *shardCount > 1 {
+		if err := xt.SelectShard(*shardIndex, *shardCount); err != nil {
+			panic(fmt.Sprintf("failed to select shard: %s", err))
+		}
+	}
+
+	return xt, h
+}
+
+// saveHistory updates the history with the results and saves it.
+func saveHistory(xt *xpytest.Xpytest, h *xpytest_proto.HistoryFile) {
+	if h == nil {
+		return
+	}
+	if err := xt.UpdateHistory(h); err != nil {
+		fmt.Fprintf(os.Stderr,
+			"[ERROR] failed to update history: %s\n", err)
+	} else if err := xpytest.SaveHistoryFile(*history, h); err != nil {
+		fmt.Fprintf(os.Stderr,
+			"[ERROR] failed to save history: %s\n", err)
+	}
+}
+
+// changedFiles returns files changed since --changed_since, or nil if it is
+// not given.
+func changedFiles() []string {
+	if *changedSince == "" {
+		return nil
+	}
+	files, err := xpytest.ChangedFiles(".", *changedSince)
+	if err != nil {
+		panic(fmt.Sprintf("failed to get changed files: %s", err))
+	}
+	fmt.Fprintf(os.Stderr, "[INFO] %d files changed since %s\n",
+		len(files), *changedSince)
+	return files
+}
+
+func buildImportGraph() *importgraph.Graph {
+	g, err := importgraph.Build(".")
+	if err != nil {
+		panic(fmt.Sprintf("failed to build import graph: %s", err))
+	}
+	return g
+}
+
+// runAffected prints tests affected by the given files, or by files changed
+// since --changed_since if no files are given.  --changed_since can also be
+// given after the subcommand.
+func runAffected(args []string) {
+	fs := flag.NewFlagSet("affected", flag.ExitOnError)
+	fs.StringVar(changedSince, "changed_since", *changedSince,
+		"print tests affected by files changed since this git reference")
+	fs.Parse(args)
+	files := fs.Args()
+	if len(files) == 0 {
+		if files = changedFiles(); files == nil {
+			panic("no changed files are given")
+		}
+	}
+	for _, f := range buildImportGraph().Affected(files) {
+		fmt.Println(f)
+	}
+}
+
+// runWatch runs tests, and it reruns tests affected by changes of files until
+// ctx is done.
+func runWatch(ctx context.Context, base *pytest.Pytest, r reporter.Reporter) {
+	dirs := xpytest.WatchDirs(flag.Args())
+	if *watchDirs != "" {
+		dirs = append(dirs, strings.Split(*watchDirs, ",")...)
+	}
+	w := xpytest.NewWatcher(dirs)
+	w.Interval = *watchInterval
+	if err := w.Snapshot(); err != nil {
+		panic(fmt.Sprintf("failed to watch files: %s", err))
+	}
+	statuses := xpytest.Statuses{}
+	changed := changedFiles()
+	for {
+		xt, h := newXpytest(base, changed)
+		if len(xt.GetTests()) > 0 {
+			if err := xt.Execute(ctx, *bucket, *thread, r); err != nil {
+				panic(fmt.Sprintf("failed to execute: %s", err))
+			}
+			saveHistory(xt, h)
+			statuses.Update(xt.TestResults)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("\nWatching %s for changes...\n", strings.Join(dirs, ", "))
+		var err error
+		if changed, err = w.Wait(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			panic(fmt.Sprintf("failed to watch files: %s", err))
+		}
+		fmt.Printf("Changed: %s\n", strings.Join(changed, ", "))
+	}
+}
 
 func main() {
 	flag.Parse()
 	ctx := context.Background()
+	if flag.Arg(0) == "affected" {
+		runAffected(flag.Args()[1:])
+		return
+	}
 
 	base := pytest.NewPytest(*python)
 	base.MarkerExpression = *markerExpression
 	base.Retry = *retry
+	base.RetryFailedCases = *retryFailedCases
 	base.Deadline = time.Minute
-	xt := xpytest.NewXpytest(base)
-
+	base.IdleTimeout = *idleTimeout
+	base.MemoryLimitMB = *memoryLimitMB
+	base.CPUTimeLimit = *cpuTimeLimit
+	base.Executor = pytest.NewExecutor(*killGracePeriod)
+	execCtx, interruption := handleSignals(ctx)
+	if *worker != "" {
+		runWorker(execCtx, base)
+		if s := interruption(); s != nil {
+			os.Exit(exitCodeForSignal(s))
+		}
+		return
+	}
 	r, err := func() (reporter.Reporter, error) {
 		if *spreadsheetID == "" {
 			return nil, nil
@@ -56,26 +396,20 @@ func main() {
 		}
 	}
 
-	for _, arg := range flag.Args() {
-		if err := xt.AddTestsWithFilePattern(arg); err != nil {
-			panic(fmt.Sprintf("failed to add tests: %s", err))
-		}
+	if *watch {
+		runWatch(execCtx, base, r)
+		return
 	}
 
-	if *hint != "" {
-		if h, err := xpytest.LoadHintFile(*hint); err != nil {
-			panic(fmt.Sprintf(
-				"failed to read hint information from file: %s: %s",
-				*hint, err))
-		} else if err := xt.ApplyHint(h); err != nil {
-			panic(fmt.Sprintf("failed to apply hint: %s", err))
-		}
-	}
-
-	if err := xt.Execute(ctx, *bucket, *thread, r); err != nil {
+	xt, h := newXpytest(base, changedFiles())
+	if *coordinator != "" {
+		executeWithCoordinator(execCtx, xt, r)
+	} else if err := xt.Execute(execCtx, *bucket, *thread, r); err != nil {
 		panic(fmt.Sprintf("failed to execute: %s", err))
 	}
 
+	saveHistory(xt, h)
+
 	if r != nil {
 		fmt.Fprintf(os.Stderr, "[DEBUG] flushing reporter...\n")
 		if err := r.Flush(ctx); err != nil {
@@ -85,6 +419,9 @@ func main() {
 	}
 
 	fmt.Printf("Overall status: %s\n", xt.Status)
+	if s := interruption(); s != nil {
+		os.Exit(exitCodeForSignal(s))
+	}
 	if xt.Status != xpytest_proto.TestResult_SUCCESS &&
 		xt.Status != xpytest_proto.TestResult_FLAKY {
 		os.Exit(1)
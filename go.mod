@@ -3,6 +3,8 @@ module github.com/chainer/xpytest
 require (
 	github.com/bmatcuk/doublestar v1.1.5
 	github.com/golang/protobuf v1.3.2
+	golang.org/x/net v0.0.0-20190503192946-f4e77d36d62c
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 	google.golang.org/api v0.9.0
+	google.golang.org/grpc v1.20.1
 )
@@ -0,0 +1,275 @@
+package farm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// Coordinator owns a queue of tests and leases them to workers.  Coordinator
+// implements xpytest_proto.FarmServer.
+type Coordinator struct {
+	// LeaseTimeout is a duration that a worker can be silent.  Tests leased
+	// to a worker are leased to other workers if the worker sends no requests
+	// for this duration.
+	LeaseTimeout time.Duration
+
+	queue    []*xpytest_proto.TestQuery
+	leases   map[string]*lease
+	workers  map[string]*workerState
+	finished map[*xpytest_proto.TestQuery]bool
+	nextID   int
+	done     bool
+	mutex    sync.Mutex
+
+	results chan *testResult
+	closed  chan struct{}
+}
+
+type lease struct {
+	test    *xpytest_proto.TestQuery
+	worker  string
+	expired bool
+}
+
+type workerState struct {
+	lastSeen time.Time
+	finished bool
+}
+
+type testResult struct {
+	test   *xpytest_proto.TestQuery
+	result *xpytest_proto.TestResult
+}
+
+// NewCoordinator creates a new Coordinator.
+func NewCoordinator() *Coordinator {
+	return &Coordinator{
+		LeaseTimeout: time.Minute,
+		leases:       map[string]*lease{},
+		workers:      map[string]*workerState{},
+		finished:     map[*xpytest_proto.TestQuery]bool{},
+		results:      make(chan *testResult),
+		closed:       make(chan struct{}),
+	}
+}
+
+// Execute leases the given tests to workers, and it calls callback for each
+// test result.  This blocks until all the tests finish.  Silent workers are
+// expired periodically so that their tests are leased again even if no workers
+// send requests.  If ctx is done, callback is called for the unfinished tests
+// with INTERRUPTED if they are leased or NOT_RUN otherwise.  Coordinator can
+// execute tests only once.
+func (c *Coordinator) Execute(
+	ctx context.Context, tests []*xpytest_proto.TestQuery,
+	callback func(*xpytest_proto.TestQuery, *xpytest_proto.TestResult),
+) error {
+	c.mutex.Lock()
+	c.queue = append(c.queue, tests...)
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.done = true
+		c.queue = nil
+		close(c.closed)
+	}()
+
+	ticker := time.NewTicker(c.expiryInterval())
+	defer ticker.Stop()
+	reported := map[*xpytest_proto.TestQuery]bool{}
+	for len(reported) < len(tests) {
+		select {
+		case r := <-c.results:
+			callback(r.test, r.result)
+			reported[r.test] = true
+		case now := <-ticker.C:
+			c.mutex.Lock()
+			c.expireWorkers(now)
+			c.mutex.Unlock()
+		case <-ctx.Done():
+			for _, r := range c.abort(tests, reported) {
+				callback(r.test, r.result)
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// abort stops leasing tests, and it returns results of the tests that are not
+// reported yet.  Results of the tests reported after this are discarded.
+func (c *Coordinator) abort(
+	tests []*xpytest_proto.TestQuery,
+	reported map[*xpytest_proto.TestQuery]bool,
+) []*testResult {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.done = true
+	leased := map[*xpytest_proto.TestQuery]bool{}
+	for _, l := range c.leases {
+		if !l.expired {
+			leased[l.test] = true
+		}
+	}
+	results := []*testResult{}
+	for _, t := range tests {
+		if reported[t] {
+			continue
+		}
+		status := xpytest_proto.TestResult_NOT_RUN
+		if leased[t] || c.finished[t] {
+			status = xpytest_proto.TestResult_INTERRUPTED
+		}
+		results = append(results, &testResult{
+			test:   t,
+			result: &xpytest_proto.TestResult{Status: status},
+		})
+	}
+	return results
+}
+
+// Drain waits until all the live workers are told that tests are finished so
+// that they can exit before the coordinator stops.
+func (c *Coordinator) Drain(ctx context.Context) error {
+	for {
+		c.mutex.Lock()
+		c.expireWorkers(time.Now())
+		pending := 0
+		for _, w := range c.workers {
+			if !w.finished {
+				pending++
+			}
+		}
+		c.mutex.Unlock()
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf(
+				"failed to wait for %d workers: %s", pending, ctx.Err())
+		case <-time.After(c.expiryInterval()):
+		}
+	}
+}
+
+// expiryInterval returns an interval to expire silent workers.
+func (c *Coordinator) expiryInterval() time.Duration {
+	if d := c.LeaseTimeout / 10; d > time.Millisecond {
+		return d
+	}
+	return time.Millisecond
+}
+
+// Lease leases a test to a worker.
+func (c *Coordinator) Lease(
+	ctx context.Context, req *xpytest_proto.LeaseRequest,
+) (*xpytest_proto.LeaseResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	w := c.touchWorker(req.GetWorker())
+	if c.done {
+		w.finished = true
+		return &xpytest_proto.LeaseResponse{Finished: true}, nil
+	}
+	if len(c.queue) == 0 {
+		return &xpytest_proto.LeaseResponse{}, nil
+	}
+	t := c.queue[0]
+	c.queue = c.queue[1:]
+	c.nextID++
+	id := fmt.Sprintf("%d", c.nextID)
+	c.leases[id] = &lease{test: t, worker: req.GetWorker()}
+	return &xpytest_proto.LeaseResponse{LeaseId: id, Test: t}, nil
+}
+
+// Report receives a result of a leased test.  A result is accepted even if
+// its lease has expired unless the test has already finished on another
+// worker.
+func (c *Coordinator) Report(
+	ctx context.Context, req *xpytest_proto.ReportRequest,
+) (*xpytest_proto.ReportResponse, error) {
+	r, err := func() (*testResult, error) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.touchWorker(req.GetWorker())
+		l, ok := c.leases[req.GetLeaseId()]
+		if !ok {
+			return nil, fmt.Errorf("unknown lease: %s", req.GetLeaseId())
+		}
+		delete(c.leases, req.GetLeaseId())
+		if c.finished[l.test] || c.done {
+			return nil, nil
+		}
+		c.finished[l.test] = true
+		for i, t := range c.queue {
+			if t == l.test {
+				c.queue = append(c.queue[:i:i], c.queue[i+1:]...)
+				break
+			}
+		}
+		return &testResult{test: l.test, result: req.GetResult()}, nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+	if r != nil {
+		select {
+		case c.results <- r:
+		case <-c.closed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return &xpytest_proto.ReportResponse{}, nil
+}
+
+// Heartbeat tells that a worker is alive.
+func (c *Coordinator) Heartbeat(
+	ctx context.Context, req *xpytest_proto.HeartbeatRequest,
+) (*xpytest_proto.HeartbeatResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.touchWorker(req.GetWorker())
+	return &xpytest_proto.HeartbeatResponse{}, nil
+}
+
+// touchWorker records that a worker is alive, and it expires silent workers.
+// CAVEAT: c.mutex must be locked when this is called.
+func (c *Coordinator) touchWorker(name string) *workerState {
+	now := time.Now()
+	w, ok := c.workers[name]
+	if !ok {
+		w = &workerState{}
+		c.workers[name] = w
+	}
+	w.lastSeen = now
+	c.expireWorkers(now)
+	return w
+}
+
+// expireWorkers forgets workers that have been silent for c.LeaseTimeout, and
+// it puts their unfinished tests back to the head of the queue.
+// CAVEAT: c.mutex must be locked when this is called.
+func (c *Coordinator) expireWorkers(now time.Time) {
+	for name, w := range c.workers {
+		if now.Sub(w.lastSeen) <= c.LeaseTimeout {
+			continue
+		}
+		delete(c.workers, name)
+		for _, l := range c.leases {
+			if l.worker != name || l.expired {
+				continue
+			}
+			l.expired = true
+			if !c.finished[l.test] && !c.done {
+				c.queue = append([]*xpytest_proto.TestQuery{l.test}, c.queue...)
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package farm_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/chainer/xpytest/pkg/farm"
+	"github.com/chainer/xpytest/pkg/pytest"
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+func TestFarm(t *testing.T) {
+	ctx := context.Background()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	c := farm.NewCoordinator()
+	c.LeaseTimeout = 200 * time.Millisecond
+	s := grpc.NewServer()
+	xpytest_proto.RegisterFarmServer(s, c)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	client := xpytest_proto.NewFarmClient(conn)
+
+	tests := []*xpytest_proto.TestQuery{}
+	for i := 0; i < 10; i++ {
+		tests = append(tests, &xpytest_proto.TestQuery{
+			File:     fmt.Sprintf("test_%d.py", i),
+			Deadline: 1.0,
+		})
+	}
+	mutex := sync.Mutex{}
+	files := []string{}
+	done := make(chan error)
+	go func() {
+		done <- c.Execute(ctx, tests, func(
+			tq *xpytest_proto.TestQuery, tr *xpytest_proto.TestResult,
+		) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if tr.GetStatus() != xpytest_proto.TestResult_SUCCESS {
+				t.Errorf("unexpected status: %s", tr.GetStatus())
+			}
+			files = append(files, tq.GetFile())
+		})
+	}()
+
+	// A lost worker leases a test and never reports its result.
+	for {
+		res, err := client.Lease(
+			ctx, &xpytest_proto.LeaseRequest{Worker: "lost"})
+		if err != nil {
+			t.Fatalf("failed to lease: %s", err)
+		}
+		if res.GetTest() != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	base := &pytest.Pytest{
+		Deadline: time.Second,
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			time.Sleep(50 * time.Millisecond)
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.05 seconds ===",
+			}, nil
+		},
+	}
+	workers := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		w := farm.NewWorker(fmt.Sprintf("worker%d", i), client, base)
+		w.Slots = 2
+		w.HeartbeatInterval = 50 * time.Millisecond
+		w.PollInterval = 10 * time.Millisecond
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if err := w.Run(ctx); err != nil {
+				t.Errorf("failed to run worker: %s", err)
+			}
+		}()
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if err := c.Drain(ctx); err != nil {
+		t.Fatalf("failed to drain: %s", err)
+	}
+	workers.Wait()
+
+	sort.Strings(files)
+	if s := strings.Join(files, ","); s != "test_0.py,test_1.py,"+
+		"test_2.py,test_3.py,test_4.py,test_5.py,test_6.py,test_7.py,"+
+		"test_8.py,test_9.py" {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+func TestCoordinatorWithCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := farm.NewCoordinator()
+	tests := []*xpytest_proto.TestQuery{
+		{File: "test_a.py", Deadline: 1.0},
+		{File: "test_b.py", Deadline: 1.0},
+		{File: "test_c.py", Deadline: 1.0},
+	}
+	results := []string{}
+	done := make(chan error)
+	go func() {
+		done <- c.Execute(ctx, tests, func(
+			tq *xpytest_proto.TestQuery, tr *xpytest_proto.TestResult,
+		) {
+			results = append(results,
+				fmt.Sprintf("%s:%s", tq.GetFile(), tr.GetStatus()))
+		})
+	}()
+
+	// test_a finishes, test_b is running, and test_c never starts.
+	lease := func() string {
+		for {
+			res, err := c.Lease(
+				ctx, &xpytest_proto.LeaseRequest{Worker: "worker"})
+			if err != nil {
+				t.Fatalf("failed to lease: %s", err)
+			}
+			if res.GetTest() != nil {
+				return res.GetLeaseId()
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if _, err := c.Report(ctx, &xpytest_proto.ReportRequest{
+		Worker:  "worker",
+		LeaseId: lease(),
+		Result: &xpytest_proto.TestResult{
+			Status: xpytest_proto.TestResult_SUCCESS,
+		},
+	}); err != nil {
+		t.Fatalf("failed to report: %s", err)
+	}
+	lease()
+	cancel()
+
+	if err := <-done; err == nil {
+		t.Fatalf("cancellation must be an error")
+	}
+	if s := strings.Join(results, ","); s != "test_a.py:SUCCESS,"+
+		"test_b.py:INTERRUPTED,test_c.py:NOT_RUN" {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
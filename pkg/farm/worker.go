@@ -0,0 +1,116 @@
+package farm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/chainer/xpytest/pkg/pytest"
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// Worker leases tests from a coordinator, runs them and reports their results.
+type Worker struct {
+	Name       string
+	Client     xpytest_proto.FarmClient
+	PytestBase *pytest.Pytest
+
+	// Slots is # of tests that run concurrently.
+	Slots int
+
+	// HeartbeatInterval is an interval of heartbeats, which should be much
+	// shorter than the lease timeout of the coordinator.
+	HeartbeatInterval time.Duration
+
+	// PollInterval is an interval to lease a test again when the coordinator
+	// has no tests for now.
+	PollInterval time.Duration
+}
+
+// NewWorker creates a new Worker.
+func NewWorker(
+	name string, client xpytest_proto.FarmClient, base *pytest.Pytest,
+) *Worker {
+	return &Worker{
+		Name:              name,
+		Client:            client,
+		PytestBase:        base,
+		Slots:             1,
+		HeartbeatInterval: 10 * time.Second,
+		PollInterval:      time.Second,
+	}
+}
+
+// Run runs tests until the coordinator tells that all tests are finished.
+func (w *Worker) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(w.HeartbeatInterval):
+			}
+			if _, err := w.Client.Heartbeat(
+				ctx, &xpytest_proto.HeartbeatRequest{Worker: w.Name},
+			); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr,
+					"[ERROR] failed to send a heartbeat: %s\n", err)
+			}
+		}
+	}()
+
+	errChan := make(chan error, w.Slots)
+	for i := 0; i < w.Slots; i++ {
+		go func() { errChan <- w.runSlot(ctx) }()
+	}
+	var err error
+	for i := 0; i < w.Slots; i++ {
+		if e := <-errChan; e != nil && err == nil {
+			err = e
+			cancel()
+		}
+	}
+	return err
+}
+
+// runSlot runs tests one by one.
+func (w *Worker) runSlot(ctx context.Context) error {
+	for {
+		res, err := w.Client.Lease(
+			ctx, &xpytest_proto.LeaseRequest{Worker: w.Name},
+			grpc.WaitForReady(true))
+		if err != nil {
+			return fmt.Errorf("failed to lease a test: %s", err)
+		}
+		if res.GetFinished() {
+			return nil
+		}
+		if res.GetTest() == nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(w.PollInterval):
+			}
+			continue
+		}
+		t := res.GetTest()
+		r, err := pytest.NewPytestWithQuery(w.PytestBase, t).Execute(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to execute pytest: %s: %s", t.File, err)
+		}
+		fmt.Println(r.Summary())
+		if _, err := w.Client.Report(ctx, &xpytest_proto.ReportRequest{
+			Worker:  w.Name,
+			LeaseId: res.GetLeaseId(),
+			Result:  r.TestResult(),
+		}, grpc.WaitForReady(true)); err != nil {
+			return fmt.Errorf("failed to report a result: %s", err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package importgraph
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var testFilePattern = regexp.MustCompile(`^test_.*\.py$`)
+
+// Graph is a dependency graph of Python files in a directory, which is built
+// from their import statements.
+type Graph struct {
+	// Root is the directory containing the Python files.
+	Root string
+
+	// files has the Python files as slash-separated paths relative to Root.
+	files map[string]bool
+
+	// importers maps a module name to the files importing it.
+	importers map[string]map[string]bool
+}
+
+// Build builds a Graph of Python files in the root directory.  Hidden
+// directories and caches are skipped.
+func Build(root string) (*Graph, error) {
+	g := &Graph{
+		Root:      root,
+		files:     map[string]bool{},
+		importers: map[string]map[string]bool{},
+	}
+	srcs := map[string]string{}
+	err := filepath.Walk(root, func(
+		p string, info os.FileInfo, err error,
+	) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if p != root && (strings.HasPrefix(name, ".") ||
+				name == "__pycache__") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(name) != ".py" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		g.files[rel] = true
+		srcs[rel] = string(src)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Python files: %s: %s", root, err)
+	}
+	for file, src := range srcs {
+		for _, name := range importedModules(file, ParseImports(src)) {
+			if g.importers[name] == nil {
+				g.importers[name] = map[string]bool{}
+			}
+			g.importers[name][file] = true
+		}
+	}
+	return g, nil
+}
+
+// Affected returns test files (i.e., test_*.py) affected by the changed
+// files, which are given as paths relative to Root.  A test file is affected
+// if it changes, if it imports a changed module directly or transitively, or
+// if conftest.py in its parent directories is affected.  Changed files do not
+// need to exist (e.g., removed modules).
+func (g *Graph) Affected(changed []string) []string {
+	affected := map[string]bool{}
+	queue := []string{}
+	visit := func(file string) {
+		if !affected[file] {
+			affected[file] = true
+			queue = append(queue, file)
+		}
+	}
+	for _, f := range changed {
+		visit(path.Clean(filepath.ToSlash(f)))
+	}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		for _, name := range g.moduleNames(file) {
+			for importer := range g.importers[name] {
+				visit(importer)
+			}
+		}
+		// pytest loads conftest.py for all the tests in its directory.
+		if path.Base(file) == "conftest.py" {
+			dir := path.Dir(file)
+			for f := range g.files {
+				if dir == "." || strings.HasPrefix(f, dir+"/") {
+					visit(f)
+				}
+			}
+		}
+	}
+	tests := []string{}
+	for file := range affected {
+		if g.files[file] && testFilePattern.MatchString(path.Base(file)) {
+			tests = append(tests, filepath.FromSlash(file))
+		}
+	}
+	sort.Strings(tests)
+	return tests
+}
+
+// moduleNames returns the module names that the file can be imported as.  One
+// is the dotted path from Root, and the other is the dotted path from the
+// first parent directory that is not a package, which pytest adds to sys.path
+// for the file.
+func (g *Graph) moduleNames(file string) []string {
+	if path.Ext(file) != ".py" {
+		return nil
+	}
+	parts := strings.Split(strings.TrimSuffix(file, ".py"), "/")
+	dirs := parts[:len(parts)-1]
+	if parts[len(parts)-1] == "__init__" {
+		parts = dirs
+	}
+	names := []string{}
+	if name := strings.Join(parts, "."); name != "" {
+		names = append(names, name)
+	}
+	i := len(dirs)
+	for i > 0 && g.files[strings.Join(dirs[:i], "/")+"/__init__.py"] {
+		i--
+	}
+	if i > 0 && i < len(parts) {
+		names = append(names, strings.Join(parts[i:], "."))
+	}
+	return names
+}
+
+// importedModules returns the names of modules that the imports in the file
+// load.  Importing a module also loads its parent packages, and names
+// imported by "from ... import" may be modules.  Relative imports are
+// resolved to dotted paths from Root.
+func importedModules(file string, imports []Import) []string {
+	names := []string{}
+	addWithParents := func(name string) {
+		parts := strings.Split(name, ".")
+		for i := range parts {
+			names = append(names, strings.Join(parts[:i+1], "."))
+		}
+	}
+	for _, imp := range imports {
+		module := imp.Module
+		if imp.Level > 0 {
+			dir := path.Dir(file)
+			for i := 1; i < imp.Level && dir != ".."; i++ {
+				if dir == "." {
+					dir = ".."
+				} else {
+					dir = path.Dir(dir)
+				}
+			}
+			if dir == ".." {
+				// The import goes beyond Root.
+				continue
+			}
+			if dir != "." {
+				module = strings.Trim(strings.Replace(dir, "/", ".", -1)+
+					"."+module, ".")
+			}
+		}
+		if module != "" {
+			addWithParents(module)
+		}
+		for _, name := range imp.Names {
+			if module != "" {
+				name = module + "." + name
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
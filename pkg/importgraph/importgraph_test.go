@@ -0,0 +1,129 @@
+package importgraph_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chainer/xpytest/pkg/importgraph"
+)
+
+func TestParseImports(t *testing.T) {
+	src := `"""Docstring.
+
+import not_imported
+"""
+import os, foo.bar as fb  # import commented
+from . import (
+    baz,
+    qux as q,
+)
+from ..pkg.mod import *
+from foo import \
+    quux
+x = "from fake import module"; import semicolon
+
+
+def f():
+    try:
+        import lazy
+    except ImportError:
+        pass
+print('import', 'nothing')
+if TYPE_CHECKING: import typing_only
+try: import optional; import another
+except ImportError: pass
+else: from . import fallback
+def g(x: int = {1: 2}[1]) -> int: from .. import nested
+import_value: int = 1
+`
+	imports := []string{}
+	for _, imp := range importgraph.ParseImports(src) {
+		imports = append(imports, fmt.Sprintf("%d:%s%v",
+			imp.Level, imp.Module, imp.Names))
+	}
+	if s := strings.Join(imports, " "); s != "0:os[] 0:foo.bar[] "+
+		"1:[baz qux] 2:pkg.mod[] 0:foo[quux] 0:semicolon[] 0:lazy[] "+
+		"0:typing_only[] 0:optional[] 0:another[] 1:[fallback] 2:[nested]" {
+		t.Fatalf("unexpected imports: %s", s)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importgraph")
+	if err != nil {
+		t.Fatalf("failed to create a directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	for name, src := range map[string]string{
+		"pkg/__init__.py":          "from pkg import core",
+		"pkg/core.py":              "from . import util",
+		"pkg/util.py":              "import os",
+		"pkg/sub/__init__.py":      "",
+		"pkg/sub/extra.py":         "from ..util import helper",
+		"pkg/standalone.py":        "",
+		"tests/conftest.py":        "import fixtures",
+		"tests/fixtures.py":        "",
+		"tests/test_core.py":       "import pkg.core",
+		"tests/test_extra.py":      "from pkg.sub import extra",
+		"tests/test_plain.py":      "",
+		"tests/sub/test_helper.py": "import helper",
+		"tests/sub/helper.py":      "import removed",
+		".hidden/test_hidden.py":   "import pkg",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create a directory: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatalf("failed to write a file: %s", err)
+		}
+	}
+	g, err := importgraph.Build(dir)
+	if err != nil {
+		t.Fatalf("failed to build a graph: %s", err)
+	}
+
+	type TestCase struct {
+		Changed  []string
+		Affected string
+	}
+	tcs := []TestCase{
+		// pkg/__init__.py imports pkg.core, so every importer of pkg is
+		// affected.
+		TestCase{
+			Changed:  []string{"pkg/util.py"},
+			Affected: "tests/test_core.py,tests/test_extra.py",
+		},
+		TestCase{
+			Changed:  []string{"pkg/sub/extra.py"},
+			Affected: "tests/test_extra.py",
+		},
+		TestCase{Changed: []string{"pkg/standalone.py"}, Affected: ""},
+		TestCase{
+			Changed:  []string{"tests/test_plain.py", "README.md"},
+			Affected: "tests/test_plain.py",
+		},
+		TestCase{
+			Changed: []string{"tests/fixtures.py"},
+			Affected: "tests/sub/test_helper.py,tests/test_core.py," +
+				"tests/test_extra.py,tests/test_plain.py",
+		},
+		TestCase{
+			Changed:  []string{"removed.py"},
+			Affected: "tests/sub/test_helper.py",
+		},
+	}
+	for i, tc := range tcs {
+		affected := g.Affected(tc.Changed)
+		for j, f := range affected {
+			affected[j] = filepath.ToSlash(f)
+		}
+		if s := strings.Join(affected, ","); s != tc.Affected {
+			t.Errorf("[case #%d] unexpected affected tests: %s", i, s)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package importgraph
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Import represents an import statement.
+type Import struct {
+	// Module is a module name (e.g., "foo.bar" for "import foo.bar" and
+	// "from foo.bar import baz").  This is empty for "from . import foo".
+	Module string
+
+	// Level is # of leading dots of a relative import (e.g., 2 for
+	// "from ..foo import bar").
+	Level int
+
+	// Names are names imported by "from ... import" (e.g., "bar" and "baz"
+	// for "from foo import bar, baz as qux").
+	Names []string
+}
+
+var modulePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// ParseImports parses import statements in Python source code.  Imports in
+// functions and conditional blocks are also returned even if they follow the
+// headers on the same lines (e.g., "if TYPE_CHECKING: import foo"), but
+// dynamic imports (e.g., importlib.import_module) are not.
+func ParseImports(src string) []Import {
+	imports := []Import{}
+	for _, line := range logicalLines(src) {
+		for _, stmt := range strings.Split(line, ";") {
+			if imp, ok := parseImport(stripHeaders(stmt)); ok {
+				imports = append(imports, imp...)
+			}
+		}
+	}
+	return imports
+}
+
+// compoundKeywords are keywords that start the header of a compound
+// statement, whose body can follow the colon on the same line (e.g.,
+// "if TYPE_CHECKING: import foo").
+var compoundKeywords = map[string]bool{
+	"if": true, "elif": true, "else": true, "try": true, "except": true,
+	"finally": true, "for": true, "while": true, "with": true, "def": true,
+	"class": true, "async": true,
+}
+
+// stripHeaders removes leading headers of compound statements (e.g., "try:"
+// for "try: import foo") so that their bodies can be parsed as simple
+// statements.
+func stripHeaders(stmt string) string {
+	for {
+		fields := strings.Fields(stmt)
+		if len(fields) == 0 ||
+			!compoundKeywords[strings.TrimSuffix(fields[0], ":")] {
+			return stmt
+		}
+		colon := -1
+		depth := 0
+		for i := 0; i < len(stmt) && colon < 0; i++ {
+			switch stmt[i] {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				if depth > 0 {
+					depth--
+				}
+			case ':':
+				if depth == 0 {
+					colon = i
+				}
+			}
+		}
+		if colon < 0 {
+			return stmt
+		}
+		stmt = stmt[colon+1:]
+	}
+}
+
+// parseImport parses a simple statement.  This returns false if it is not an
+// import statement.
+func parseImport(stmt string) ([]Import, bool) {
+	fields := strings.Fields(stmt)
+	if len(fields) < 2 {
+		return nil, false
+	}
+	switch fields[0] {
+	case "import":
+		imports := []Import{}
+		for _, name := range splitNames(strings.Join(fields[1:], " ")) {
+			if modulePattern.MatchString(name) {
+				imports = append(imports, Import{Module: name})
+			}
+		}
+		return imports, true
+	case "from":
+		if len(fields) < 4 || fields[2] != "import" {
+			return nil, false
+		}
+		module := strings.TrimLeft(fields[1], ".")
+		imp := Import{Module: module, Level: len(fields[1]) - len(module)}
+		if module != "" && !modulePattern.MatchString(module) {
+			return nil, false
+		}
+		for _, name := range splitNames(strings.Join(fields[3:], " ")) {
+			if modulePattern.MatchString(name) {
+				imp.Names = append(imp.Names, name)
+			}
+		}
+		return []Import{imp}, true
+	}
+	return nil, false
+}
+
+// splitNames splits comma-separated names, removing parentheses and aliases
+// (e.g., "(foo as bar, baz)" to "foo" and "baz").
+func splitNames(s string) []string {
+	s = strings.NewReplacer("(", " ", ")", " ").Replace(s)
+	names := []string{}
+	for _, part := range strings.Split(s, ",") {
+		if fields := strings.Fields(part); len(fields) > 0 {
+			names = append(names, fields[0])
+		}
+	}
+	return names
+}
+
+// logicalLines splits Python source code into logical lines.  Comments are
+// removed, string literals are replaced with empty strings, and lines joined
+// by brackets or backslashes are concatenated.
+func logicalLines(src string) []string {
+	lines := []string{}
+	line := strings.Builder{}
+	depth := 0
+	flush := func() {
+		if s := strings.TrimSpace(line.String()); s != "" {
+			lines = append(lines, s)
+		}
+		line.Reset()
+	}
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch c {
+		case '#':
+			for i+1 < len(src) && src[i+1] != '\n' {
+				i++
+			}
+		case '\'', '"':
+			quote := src[i : i+1]
+			if strings.HasPrefix(src[i:], strings.Repeat(quote, 3)) {
+				quote = strings.Repeat(quote, 3)
+			}
+			i += len(quote)
+			for i < len(src) && !strings.HasPrefix(src[i:], quote) {
+				if src[i] == '\\' {
+					i++
+				} else if src[i] == '\n' && len(quote) == 1 {
+					// An unterminated string ends at the end of the line.
+					i--
+					break
+				}
+				i++
+			}
+			i += len(quote) - 1
+			line.WriteString(`""`)
+		case '\\':
+			if i+1 < len(src) && src[i+1] == '\n' {
+				i++
+			}
+			line.WriteByte(' ')
+		case '\n':
+			if depth > 0 {
+				line.WriteByte(' ')
+			} else {
+				flush()
+			}
+		case '(', '[', '{':
+			depth++
+			line.WriteByte(c)
+		case ')', ']', '}':
+			if depth > 0 {
+				depth--
+			}
+			line.WriteByte(c)
+		default:
+			line.WriteByte(c)
+		}
+	}
+	flush()
+	return lines
+}
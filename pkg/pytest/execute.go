@@ -1,14 +1,15 @@
 package pytest
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -17,9 +18,78 @@ import (
 	xpytest_proto "github.com/chainer/xpytest/proto"
 )
 
-// Execute executes a command.
+// ExecuteOptions are options of a command execution.  Pytest passes them to
+// its Executor through a context so that executors not supporting them can
+// ignore them.
+type ExecuteOptions struct {
+	// IdleTimeout is a duration that the command can print nothing for.  The
+	// command is terminated as HUNG after this.  No idle timeout if this is 0.
+	IdleTimeout time.Duration
+
+	// MemoryLimitMB is a limit of the address space in MB of each process of
+	// the command (RLIMIT_AS).  This is clamped to the hard limit inherited
+	// from xpytest.  No limit if this is 0.
+	MemoryLimitMB int
+
+	// CPUTimeLimit is a limit of CPU time of each process of the command
+	// (RLIMIT_CPU), which is rounded up to seconds.  This is clamped below the
+	// hard limit inherited from xpytest.  No limit if this is 0.
+	CPUTimeLimit time.Duration
+}
+
+type executeOptionsKey struct{}
+
+// WithExecuteOptions returns a copy of ctx carrying the options.
+func WithExecuteOptions(
+	ctx context.Context, opts ExecuteOptions,
+) context.Context {
+	return context.WithValue(ctx, executeOptionsKey{}, opts)
+}
+
+// ExecuteOptionsFromContext returns the options that ctx carries.  The zero
+// value is returned if ctx carries no options.
+func ExecuteOptionsFromContext(ctx context.Context) ExecuteOptions {
+	opts, _ := ctx.Value(executeOptionsKey{}).(ExecuteOptions)
+	return opts
+}
+
+// DefaultKillGracePeriod is a duration that Execute waits for processes to
+// exit after sending SIGTERM and before sending SIGKILL.
+const DefaultKillGracePeriod = 5 * time.Second
+
+// pipeDrainTimeout is a duration to wait for the I/O threads to read the rest
+// of outputs after the command exits.
+const pipeDrainTimeout = time.Second
+
+// Execute executes a command.  When the deadline or the idle timeout given by
+// ExecuteOptions passes, Python processes of the command dump their tracebacks,
+// and then the process group of the command is terminated with SIGTERM, and
+// SIGKILL is sent to processes still alive after DefaultKillGracePeriod.  The
+// process group is terminated in the same way when ctx is done.  The resource
+// limits given by ExecuteOptions are applied to the processes, and the command
+// results in LIMIT_EXCEEDED if it exceeds any of them.
 func Execute(
 	ctx context.Context, args []string, deadline time.Duration, env []string,
+) (*xpytest_proto.TestResult, error) {
+	return execute(ctx, args, deadline, DefaultKillGracePeriod, env)
+}
+
+// NewExecutor returns a function that executes a command as Execute does, but
+// with the given grace period between SIGTERM and SIGKILL.
+func NewExecutor(gracePeriod time.Duration) func(
+	context.Context, []string, time.Duration, []string,
+) (*xpytest_proto.TestResult, error) {
+	return func(
+		ctx context.Context, args []string, deadline time.Duration,
+		env []string,
+	) (*xpytest_proto.TestResult, error) {
+		return execute(ctx, args, deadline, gracePeriod, env)
+	}
+}
+
+func execute(
+	ctx context.Context, args []string, deadline, gracePeriod time.Duration,
+	env []string,
 ) (*xpytest_proto.TestResult, error) {
 	startTime := time.Now()
 
@@ -33,7 +103,7 @@ func Execute(
 	temporaryResult := &xpytest_proto.TestResult{}
 	go func() {
 		err := executeInternal(
-			ctx, args, deadline, env, temporaryResult)
+			ctx, args, deadline, gracePeriod, env, temporaryResult)
 		resultChan <- &executeResult{testResult: temporaryResult, err: err}
 		close(done)
 	}()
@@ -41,7 +111,8 @@ func Execute(
 	go func() {
 		select {
 		case <-done:
-		case <-time.After(deadline + 5*time.Second):
+		case <-time.After(
+			deadline + tracebackTimeout + gracePeriod + 5*time.Second):
 			r := proto.Clone(temporaryResult).(*xpytest_proto.TestResult)
 			r.Status = xpytest_proto.TestResult_TIMEOUT
 			resultChan <- &executeResult{testResult: r, err: nil}
@@ -61,14 +132,25 @@ func Execute(
 }
 
 func executeInternal(
-	ctx context.Context, args []string, deadline time.Duration, env []string,
-	result *xpytest_proto.TestResult,
+	ctx context.Context, args []string, deadline, gracePeriod time.Duration,
+	env []string, result *xpytest_proto.TestResult,
 ) error {
 	// Prepare a Cmd object.
 	if len(args) == 0 {
 		return fmt.Errorf("# of args must be larger than 0")
 	}
-	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to start command: %s", err)
+	}
+	opts, err := clampLimits(ExecuteOptionsFromContext(ctx))
+	if err != nil {
+		result.Status = xpytest_proto.TestResult_INTERNAL
+		result.Stderr = fmt.Sprintf("[ERROR] %s\n", err)
+		return nil
+	}
+	limitedArgs := limitCommand(args, opts)
+	cmd := exec.Command(limitedArgs[0], limitedArgs[1:]...)
+	setProcessGroup(cmd)
 
 	// Open pipes.
 	stdoutPipe, err := cmd.StdoutPipe()
@@ -85,6 +167,21 @@ func executeInternal(
 		env = []string{}
 	}
 	env = append(env, os.Environ()...)
+
+	// Load a bootstrap so that Python processes can dump their tracebacks on
+	// timeout and record exceeded limits.  It is loaded only if either is
+	// needed.
+	var dumper *tracebackDumper
+	tracebacks := deadline > 0 || opts.IdleTimeout > 0
+	if tracebacks || opts.MemoryLimitMB > 0 || opts.CPUTimeLimit > 0 {
+		if d, err := newTracebackDumper(tracebacks, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s\n", err)
+		} else {
+			dumper = d
+			defer dumper.close()
+			env = dumper.env(env)
+		}
+	}
 	cmd.Env = env
 
 	// Start the command.
@@ -102,61 +199,164 @@ func executeInternal(
 		}()
 	}
 
-	// Run I/O threads.
+	// Run I/O threads.  They read the pipes until all the processes holding
+	// them exit, and they record the time of the last output for the idle
+	// timeout.  NOTE: Outputs are not read line by line because pytest prints
+	// progress without newlines.
+	lastOutput := time.Now().UnixNano()
+	var pipesClosed int32
 	readAll := func(pipe io.ReadCloser, out *string) {
-		s := bufio.NewReaderSize(pipe, 128)
+		buf := make([]byte, 4096)
 		for {
-			line, err := s.ReadSlice('\n')
+			n, err := pipe.Read(buf)
+			if n > 0 {
+				*out += string(buf[:n])
+				atomic.StoreInt64(&lastOutput, time.Now().UnixNano())
+			}
 			if err == io.EOF {
 				break
-			} else if err != nil && err != bufio.ErrBufferFull {
-				if err.Error() != "read |0: file already closed" {
-					fmt.Fprintf(os.Stderr,
-						"[ERROR] failed to read from pipe: %s\n", err)
+			} else if err != nil {
+				if atomic.LoadInt32(&pipesClosed) != 0 {
+					break
 				}
+				fmt.Fprintf(os.Stderr,
+					"[ERROR] failed to read from pipe: %s\n", err)
 				break
 			}
-			*out += string(line)
 		}
 		pipe.Close()
 	}
 	async(func() { readAll(stdoutPipe, &result.Stdout) })
 	async(func() { readAll(stderrPipe, &result.Stderr) })
 
-	// Run timer thread.
-	var timeout bool
-	cmdIsDone := make(chan struct{}, 1)
-	async(func() {
-		select {
-		case <-cmdIsDone:
-		case <-time.After(deadline):
-			timeout = true
-			cmd.Process.Kill()
-		}
-	})
+	// Wait for the command.  NOTE: cmd.Wait is not used because it closes the
+	// pipes before the I/O threads read everything.
+	type waitResult struct {
+		state *os.ProcessState
+		err   error
+	}
+	waitChan := make(chan waitResult, 1)
+	go func() {
+		state, err := cmd.Process.Wait()
+		waitChan <- waitResult{state, err}
+	}()
 
-	// Wait for the command.
-	err = cmd.Wait()
-	if err != nil {
+	// Run a watchdog thread for the idle timeout.
+	idle := make(chan struct{})
+	exited := make(chan struct{})
+	defer close(exited)
+	idleTimeout := opts.IdleTimeout
+	if idleTimeout > 0 {
+		go func() {
+			for {
+				last := time.Unix(0, atomic.LoadInt64(&lastOutput))
+				select {
+				case <-exited:
+					return
+				case <-time.After(last.Add(idleTimeout).Sub(time.Now())):
+				}
+				if time.Now().Sub(time.Unix(
+					0, atomic.LoadInt64(&lastOutput))) >= idleTimeout {
+					close(idle)
+					return
+				}
+			}
+		}()
+	}
+
+	var w waitResult
+	var timeout, hung, interrupted bool
+	reaped := 0
+	terminate := func() {
+		if dumper != nil && !interrupted {
+			result.Tracebacks = dumper.dump(cmd.Process.Pid)
+		}
+		// Terminate the process tree because child processes (e.g., workers
+		// of pytest-xdist) may keep running and holding resources.
+		if reaped, err = terminateProcessGroup(cmd, gracePeriod); err != nil {
+			fmt.Fprintf(os.Stderr,
+				"[ERROR] failed to terminate processes: %s\n", err)
+		}
+		w = <-waitChan
+	}
+	select {
+	case w = <-waitChan:
+	case <-time.After(deadline):
+		timeout = true
+		terminate()
+	case <-idle:
+		hung = true
+		terminate()
+	case <-ctx.Done():
+		interrupted = true
+		// Terminate the process tree because child processes (e.g., workers
+		// of pytest-xdist) do not receive signals sent to xpytest.
+		terminate()
+	}
+	if w.err != nil {
+		return fmt.Errorf("failed to wait a command: %s", w.err)
+	}
+	if !w.state.Success() {
 		fmt.Fprintf(os.Stderr, "[DEBUG] failed to wait a command: %s: %s\n",
-			strings.Join(args, " "), err)
-		cmd.Process.Kill()
+			strings.Join(args, " "), w.state)
+	}
+	if !timeout && !hung && !interrupted {
+		// Processes left by the command would hold the pipes and resources.
+		n, err := reapProcessGroup(cmd, gracePeriod)
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"[ERROR] failed to terminate stray processes: %s\n", err)
+		}
+		reaped += n
+	}
+	if reaped > 0 {
+		fmt.Fprintf(os.Stderr, "[DEBUG] terminated %d stray processes: %s\n",
+			reaped, strings.Join(args, " "))
+	}
+	result.ReapedProcesses = int32(reaped)
+	result.UserTime = float32(w.state.UserTime().Seconds())
+	result.SystemTime = float32(w.state.SystemTime().Seconds())
+	setResourceUsage(result, w.state)
+	var exceeded map[string]bool
+	if dumper != nil {
+		exceeded = dumper.exceededLimits()
+	}
+
+	// Wait for the I/O threads.  Processes escaping from the process group
+	// (e.g., daemons in another session) may keep holding the pipes, so the
+	// pipes are closed after a while as cmd.Wait does.
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(pipeDrainTimeout):
+		atomic.StoreInt32(&pipesClosed, 1)
+		stdoutPipe.Close()
+		stderrPipe.Close()
+		<-drained
 	}
-	close(cmdIsDone)
-	wg.Wait()
 
 	// Get the last line.
 	if timeout {
 		result.Status = xpytest_proto.TestResult_TIMEOUT
-	} else if cmd.ProcessState.Success() {
+	} else if hung {
+		result.Status = xpytest_proto.TestResult_HUNG
+	} else if failedToLimit(w.state, opts, result.Stderr) {
+		result.Status = xpytest_proto.TestResult_INTERNAL
+	} else if limit := exceededLimit(
+		w.state, opts, exceeded); limit != "" {
+		result.Status = xpytest_proto.TestResult_LIMIT_EXCEEDED
+		result.ExceededLimit = limit
+	} else if w.state.Success() {
 		result.Status = xpytest_proto.TestResult_SUCCESS
 	} else if hasNoTests := func() bool {
 		// NOTE: pytest fails with code=5 if there are no tests:
 		// https://docs.pytest.org/en/latest/usage.html
-		if err, ok := err.(*exec.ExitError); ok {
-			if s, ok := err.Sys().(syscall.WaitStatus); ok {
-				return s.ExitStatus() == 5
-			}
+		if s, ok := w.state.Sys().(syscall.WaitStatus); ok {
+			return s.ExitStatus() == 5
 		}
 		return false
 	}(); hasNoTests {
@@ -167,3 +367,31 @@ func executeInternal(
 
 	return nil
 }
+
+// exceededLimit returns the limit that the failed command exceeded (e.g.,
+// "memory limit of 1024 MB"), or "" if it exceeded no limit.  The command
+// exceeded a limit if the command itself was killed by RLIMIT_CPU or any of
+// its Python processes recorded the limit in exceeded (e.g., because
+// RLIMIT_AS made an allocation fail with MemoryError).
+func exceededLimit(
+	state *os.ProcessState, opts ExecuteOptions, exceeded map[string]bool,
+) string {
+	if state.Success() {
+		return ""
+	}
+	if opts.CPUTimeLimit > 0 && (exceeded["cpu"] ||
+		killedByCPUTimeLimit(state, opts.CPUTimeLimit)) {
+		return fmt.Sprintf("CPU time limit of %.0f seconds",
+			cpuTimeLimitSeconds(opts.CPUTimeLimit))
+	}
+	if opts.MemoryLimitMB > 0 && exceeded["memory"] {
+		return fmt.Sprintf("memory limit of %d MB", opts.MemoryLimitMB)
+	}
+	return ""
+}
+
+// cpuTimeLimitSeconds returns the CPU time limit in seconds, which is rounded
+// up because RLIMIT_CPU is in seconds.
+func cpuTimeLimitSeconds(d time.Duration) float64 {
+	return math.Ceil(d.Seconds())
+}
@@ -4,6 +4,12 @@ package pytest_test
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -73,3 +79,352 @@ func TestExecuteWithEnvironmentVariables(t *testing.T) {
 		t.Fatalf("unexpected output: %s", r.Stdout)
 	}
 }
+
+func TestExecuteWithCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pidFile, err := ioutil.TempFile("", "xpytest-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary file: %s", err)
+	}
+	pidFile.Close()
+	defer os.Remove(pidFile.Name())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	startTime := time.Now()
+	if _, err := pytest.Execute(ctx, []string{"bash", "-c",
+		"sleep 10 & echo $! > " + pidFile.Name() + "; wait"},
+		10*time.Second, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if d := time.Now().Sub(startTime); d > 5*time.Second {
+		t.Fatalf("command is not killed: %s", d)
+	}
+
+	// A child process must also be killed (or be a zombie).
+	time.Sleep(100 * time.Millisecond)
+	buf, err := ioutil.ReadFile(pidFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read PID file: %s", err)
+	}
+	stat, err := ioutil.ReadFile(
+		fmt.Sprintf("/proc/%s/stat", strings.TrimSpace(string(buf))))
+	if err == nil && !strings.Contains(string(stat), ") Z ") {
+		t.Fatalf("child process is still alive: %s", stat)
+	}
+}
+
+func TestExecuteWithCancellationAndGracePeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	execute := pytest.NewExecutor(time.Second)
+	time.AfterFunc(100*time.Millisecond, cancel)
+	// The command can clean up on SIGTERM as on timeout.
+	r, err := execute(ctx, []string{"bash", "-c",
+		"trap 'echo terminated; exit 1' TERM; sleep 10 & wait"},
+		10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Stdout != "terminated\n" {
+		t.Fatalf("unexpected output: %s", r.Stdout)
+	}
+}
+
+func TestExecuteWithTimeoutAndGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	execute := pytest.NewExecutor(time.Second)
+	// The command can clean up on SIGTERM, and its child is also terminated.
+	r, err := execute(ctx, []string{"bash", "-c",
+		"trap 'echo terminated; exit 1' TERM; sleep 10 & wait"},
+		100*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_TIMEOUT {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+	if r.Stdout != "terminated\n" {
+		t.Fatalf("unexpected output: %s", r.Stdout)
+	}
+	if r.ReapedProcesses != 1 {
+		t.Fatalf("unexpected # of reaped processes: %d", r.ReapedProcesses)
+	}
+
+	// Processes ignoring SIGTERM are killed after the grace period.
+	execute = pytest.NewExecutor(200 * time.Millisecond)
+	startTime := time.Now()
+	r, err = execute(ctx, []string{"bash", "-c", "trap '' TERM; sleep 10"},
+		100*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_TIMEOUT {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+	if d := time.Now().Sub(startTime); d > 5*time.Second {
+		t.Fatalf("command is not killed: %s", d)
+	}
+}
+
+func TestExecuteWithStrayProcesses(t *testing.T) {
+	ctx := context.Background()
+	startTime := time.Now()
+	r, err := pytest.Execute(ctx, []string{"bash", "-c",
+		"sleep 10 & sleep 10 & echo done"}, 10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_SUCCESS {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+	if r.Stdout != "done\n" {
+		t.Fatalf("unexpected output: %s", r.Stdout)
+	}
+	if r.ReapedProcesses != 2 {
+		t.Fatalf("unexpected # of reaped processes: %d", r.ReapedProcesses)
+	}
+	if d := time.Now().Sub(startTime); d > 5*time.Second {
+		t.Fatalf("stray processes are not terminated: %s", d)
+	}
+}
+
+func TestExecuteWithDaemonHoldingOutput(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid is not available")
+	}
+	ctx := context.Background()
+	startTime := time.Now()
+	// The daemon escapes from the process group and keeps holding stdout.
+	r, err := pytest.Execute(ctx, []string{"bash", "-c",
+		"setsid sleep 10 & echo done"}, 10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_SUCCESS {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+	if r.Stdout != "done\n" {
+		t.Fatalf("unexpected output: %s", r.Stdout)
+	}
+	if d := time.Now().Sub(startTime); d > 5*time.Second {
+		t.Fatalf("pipes held by a daemon are not closed: %s", d)
+	}
+}
+
+func TestExecuteWithResourceUsage(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not available")
+	}
+	ctx := context.Background()
+	// Usage of a grandchild process should be included.
+	r, err := pytest.Execute(ctx, []string{"bash", "-c",
+		"python3 -c 'x = b\"x\" * (64 << 20)'; echo done"},
+		10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_SUCCESS {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+	if r.MaxRss < 64<<20 {
+		t.Fatalf("unexpected max RSS: %d", r.MaxRss)
+	}
+	if r.UserTime+r.SystemTime <= 0 {
+		t.Fatalf("unexpected CPU time: %f user, %f sys",
+			r.UserTime, r.SystemTime)
+	}
+}
+
+func TestExecuteWithTracebacks(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not available")
+	}
+	ctx := context.Background()
+	r, err := pytest.Execute(ctx, []string{"python3", "-c",
+		"import time\nwhile True: time.sleep(0.01)"}, time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_TIMEOUT {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+	if !strings.HasPrefix(r.Tracebacks, "Process ") || !strings.Contains(
+		r.Tracebacks, `File "<string>", line 2 in <module>`) {
+		t.Fatalf("unexpected tracebacks: %s", r.Tracebacks)
+	}
+
+	// The bootstrap must not break Python processes.
+	r, err = pytest.Execute(ctx, []string{"python3", "-c",
+		"import sitecustomize"}, 10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_SUCCESS || r.Stderr != "" {
+		t.Fatalf("unexpected result: %s: %s", r.Status, r.Stderr)
+	}
+
+	// The bootstrap must not install hooks for limits that are not set.
+	r, err = pytest.Execute(ctx, []string{"python3", "-c",
+		"import os, signal, sys\n" +
+			"assert sys.excepthook is sys.__excepthook__\n" +
+			"assert signal.getsignal(signal.SIGXCPU) == signal.SIG_DFL\n" +
+			"assert 'PYTEST_PLUGINS' not in os.environ"},
+		10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_SUCCESS || r.Stderr != "" {
+		t.Fatalf("unexpected result: %s: %s", r.Status, r.Stderr)
+	}
+}
+
+func TestExecuteWithIdleTimeout(t *testing.T) {
+	ctx := pytest.WithExecuteOptions(context.Background(),
+		pytest.ExecuteOptions{IdleTimeout: 300 * time.Millisecond})
+	startTime := time.Now()
+	r, err := pytest.Execute(ctx, []string{"bash", "-c",
+		"echo started; sleep 10"}, 10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_HUNG {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+	if r.Stdout != "started\n" {
+		t.Fatalf("unexpected output: %s", r.Stdout)
+	}
+	if d := time.Now().Sub(startTime); d > 5*time.Second {
+		t.Fatalf("command is not terminated: %s", d)
+	}
+
+	// Outputs without newlines (e.g., progress of pytest) are activities.
+	r, err = pytest.Execute(ctx, []string{"bash", "-c",
+		"for i in 1 2 3 4 5; do printf .; sleep 0.1; done"},
+		10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_SUCCESS {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+	if r.Stdout != "....." {
+		t.Fatalf("unexpected output: %s", r.Stdout)
+	}
+}
+
+func TestExecuteWithMemoryLimit(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not available")
+	}
+	ctx := pytest.WithExecuteOptions(context.Background(),
+		pytest.ExecuteOptions{MemoryLimitMB: 256})
+	r, err := pytest.Execute(ctx, []string{"python3", "-c",
+		"x = b'x' * (16 << 20)"}, 10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_SUCCESS {
+		t.Fatalf("unexpected status: %s: %s", r.Status, r.Stderr)
+	}
+
+	r, err = pytest.Execute(ctx, []string{"python3", "-c",
+		"x = b'x' * (1 << 30)"}, 10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_LIMIT_EXCEEDED {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+	if r.ExceededLimit != "memory limit of 256 MB" {
+		t.Fatalf("unexpected exceeded limit: %s", r.ExceededLimit)
+	}
+
+	// A MemoryError of a child process is also detected.
+	r, err = pytest.Execute(ctx, []string{"bash", "-c",
+		"python3 -c \"x = b'x' * (1 << 30)\"; echo done; exit 1"},
+		10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_LIMIT_EXCEEDED {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+
+	// Outputs are not regarded as out-of-memory errors.
+	r, err = pytest.Execute(ctx, []string{"bash", "-c",
+		"echo MemoryError; exit 1"}, 10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_FAILED {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+}
+
+func TestExecuteWithCPUTimeLimit(t *testing.T) {
+	ctx := pytest.WithExecuteOptions(context.Background(),
+		pytest.ExecuteOptions{CPUTimeLimit: 500 * time.Millisecond})
+	startTime := time.Now()
+	r, err := pytest.Execute(ctx, []string{"bash", "-c",
+		"while :; do :; done"}, 10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_LIMIT_EXCEEDED {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+	if r.ExceededLimit != "CPU time limit of 1 seconds" {
+		t.Fatalf("unexpected exceeded limit: %s", r.ExceededLimit)
+	}
+	if d := time.Now().Sub(startTime); d > 5*time.Second {
+		t.Fatalf("command is not terminated: %s", d)
+	}
+
+	// A child process killed by the limit is also detected.
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not available")
+	}
+	r, err = pytest.Execute(ctx, []string{"bash", "-c",
+		"python3 -c 'while True: pass'; echo done; exit 1"},
+		10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_LIMIT_EXCEEDED {
+		t.Fatalf("unexpected status: %s", r.Status)
+	}
+	if r.Stdout != "done\n" {
+		t.Fatalf("unexpected output: %s", r.Stdout)
+	}
+}
+
+func TestExecuteWithLowerHardLimit(t *testing.T) {
+	// NOTE: The hard limit of this process cannot be raised again, but it is
+	// long enough for the tests.
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CPU, &rlim); err != nil {
+		t.Fatalf("failed to get CPU time limit: %s", err)
+	}
+	if rlim.Max > 600 {
+		rlim.Max = 600
+	}
+	if rlim.Cur > rlim.Max {
+		rlim.Cur = rlim.Max
+	}
+	if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &rlim); err != nil {
+		t.Fatalf("failed to set CPU time limit: %s", err)
+	}
+
+	// The limit is clamped below the hard limit so that SIGXCPU is sent.
+	ctx := pytest.WithExecuteOptions(context.Background(),
+		pytest.ExecuteOptions{CPUTimeLimit: time.Hour})
+	r, err := pytest.Execute(ctx, []string{"bash", "-c",
+		"ulimit -S -t; ulimit -H -t"}, 10*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if r.Status != xpytest_proto.TestResult_SUCCESS {
+		t.Fatalf("unexpected status: %s: %s", r.Status, r.Stderr)
+	}
+	if s := fmt.Sprintf("%d\n%d\n", rlim.Max-1, rlim.Max); r.Stdout != s {
+		t.Fatalf("unexpected limits: %s", r.Stdout)
+	}
+}
@@ -0,0 +1,260 @@
+// +build !windows
+
+package pytest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// setProcessGroup makes the command run in a new process group so that its
+// process tree can be killed at once.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills all the processes in the process group of the
+// command.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGKILL)
+}
+
+// terminateProcessGroup terminates all the processes in the process group of
+// the command.  This sends SIGTERM first so that the processes can clean up,
+// and it sends SIGKILL to the processes still alive after gracePeriod.  This
+// returns # of the processes other than the command itself.
+func terminateProcessGroup(
+	cmd *exec.Cmd, gracePeriod time.Duration,
+) (int, error) {
+	pids := processGroup(cmd.Process.Pid)
+	if len(pids) == 0 {
+		return 0, nil
+	}
+	stray := 0
+	for _, pid := range pids {
+		if pid != cmd.Process.Pid {
+			stray++
+		}
+	}
+	if err := signalProcessGroup(cmd, syscall.SIGTERM); err != nil {
+		return stray, err
+	}
+	timeout := time.After(gracePeriod)
+	for len(processGroup(cmd.Process.Pid)) > 0 {
+		select {
+		case <-timeout:
+			return stray, killProcessGroup(cmd)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return stray, nil
+}
+
+// reapProcessGroup terminates stray processes left in the process group of
+// the command after the command exits (e.g., subprocesses of tests).  This
+// returns # of the processes.
+func reapProcessGroup(
+	cmd *exec.Cmd, gracePeriod time.Duration,
+) (int, error) {
+	return terminateProcessGroup(cmd, gracePeriod)
+}
+
+// dumpTraceback makes the Python process dump its tracebacks by sending
+// SIGUSR1 (see tracebackBootstrap).  This fails if the process is not in the
+// process group because its PID may have been reused by another process.
+func dumpTraceback(pgid, pid int) error {
+	if g, err := syscall.Getpgid(pid); err != nil {
+		return err
+	} else if g != pgid {
+		return fmt.Errorf("process %d is not in process group %d", pid, pgid)
+	}
+	return syscall.Kill(pid, syscall.SIGUSR1)
+}
+
+// setResourceUsage records the maximum resident set size and # of context
+// switches of the exited command.
+func setResourceUsage(
+	result *xpytest_proto.TestResult, state *os.ProcessState,
+) {
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return
+	}
+	// NOTE: ru_maxrss is in kilobytes on Linux, but it is in bytes on macOS.
+	result.MaxRss = int64(ru.Maxrss)
+	if runtime.GOOS != "darwin" {
+		result.MaxRss *= 1024
+	}
+	result.VoluntaryContextSwitches = int64(ru.Nvcsw)
+	result.InvoluntaryContextSwitches = int64(ru.Nivcsw)
+}
+
+// limitErrorStatus is an exit status of a command that failed to set its
+// resource limits, and limitErrorMessage is printed to its stderr.  The status
+// follows coreutils (e.g., nice and timeout), which exit with 125 if they fail
+// by themselves.
+const (
+	limitErrorStatus  = 125
+	limitErrorMessage = "failed to set resource limits"
+)
+
+// clampLimits returns opts whose resource limits are clamped to the current
+// hard limits, which cannot be raised.  The CPU time limit is kept below the
+// hard limit so that the kernel can send SIGXCPU (see limitCommand).
+func clampLimits(opts ExecuteOptions) (ExecuteOptions, error) {
+	hardLimit := func(resource int) (uint64, error) {
+		var rlim syscall.Rlimit
+		if err := syscall.Getrlimit(resource, &rlim); err != nil {
+			return 0, err
+		}
+		return uint64(rlim.Max), nil
+	}
+	if opts.MemoryLimitMB > 0 {
+		hard, err := hardLimit(syscall.RLIMIT_AS)
+		if err != nil {
+			return opts, fmt.Errorf("failed to get memory limit: %s", err)
+		}
+		if uint64(opts.MemoryLimitMB) > hard>>20 {
+			opts.MemoryLimitMB = int(hard >> 20)
+		}
+	}
+	if opts.CPUTimeLimit > 0 {
+		hard, err := hardLimit(syscall.RLIMIT_CPU)
+		if err != nil {
+			return opts, fmt.Errorf("failed to get CPU time limit: %s", err)
+		}
+		if seconds := cpuTimeLimitSeconds(opts.CPUTimeLimit); hard == 0 {
+			opts.CPUTimeLimit = 0
+		} else if uint64(seconds) >= hard {
+			opts.CPUTimeLimit = time.Duration(hard-1) * time.Second
+		}
+	}
+	return opts, nil
+}
+
+// limitCommand returns args that run the command with the resource limits of
+// opts, which should be clamped by clampLimits.  A shell sets the limits with
+// ulimit and then execs the command, so the command keeps the PID of the
+// shell.  The shell exits with limitErrorStatus if it fails to set the limits.
+func limitCommand(args []string, opts ExecuteOptions) []string {
+	limits := []string{}
+	if opts.MemoryLimitMB > 0 {
+		// NOTE: ulimit -v is in kilobytes.
+		limits = append(limits,
+			fmt.Sprintf("ulimit -v %d", opts.MemoryLimitMB*1024))
+	}
+	if opts.CPUTimeLimit > 0 {
+		// NOTE: The kernel sends SIGKILL instead of SIGXCPU if the soft limit
+		// equals the hard limit, so the hard limit is one second longer.
+		seconds := cpuTimeLimitSeconds(opts.CPUTimeLimit)
+		limits = append(limits, fmt.Sprintf(
+			"ulimit -S -t %.0f && ulimit -H -t %.0f", seconds, seconds+1))
+	}
+	if len(limits) == 0 {
+		return args
+	}
+	return append([]string{"/bin/sh", "-c", fmt.Sprintf(
+		`%s || { echo "%s" >&2; exit %d; }; exec "$@"`,
+		strings.Join(limits, " && "), limitErrorMessage, limitErrorStatus),
+		"sh"}, args...)
+}
+
+// failedToLimit returns true if the exited command failed to set its resource
+// limits (see limitCommand).
+func failedToLimit(
+	state *os.ProcessState, opts ExecuteOptions, stderr string,
+) bool {
+	if opts.MemoryLimitMB <= 0 && opts.CPUTimeLimit <= 0 {
+		return false
+	}
+	s, ok := state.Sys().(syscall.WaitStatus)
+	return ok && s.Exited() && s.ExitStatus() == limitErrorStatus &&
+		strings.Contains(stderr, limitErrorMessage)
+}
+
+// killedByCPUTimeLimit returns true if the exited command was killed because
+// of RLIMIT_CPU.  The kernel sends SIGXCPU when a process reaches the soft
+// limit, and it sends SIGKILL when the process reaches the hard limit (e.g.,
+// because the process ignores SIGXCPU).
+func killedByCPUTimeLimit(
+	state *os.ProcessState, limit time.Duration,
+) bool {
+	s, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !s.Signaled() {
+		return false
+	}
+	return s.Signal() == syscall.SIGXCPU || s.Signal() == syscall.SIGKILL &&
+		state.UserTime()+state.SystemTime() >= limit
+}
+
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	err := syscall.Kill(-cmd.Process.Pid, sig)
+	if err == syscall.ESRCH {
+		// The processes have already exited.
+		return nil
+	}
+	return err
+}
+
+// processGroup returns IDs of live processes in the process group.  Zombie
+// processes are excluded because they are not running and just waiting for
+// their parents.  This reads /proc if available, or it runs ps otherwise.
+func processGroup(pgid int) []int {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		return processGroupWithPs(pgid)
+	}
+	pids := []int{}
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+	for _, stat := range stats {
+		buf, err := ioutil.ReadFile(stat)
+		if err != nil {
+			continue
+		}
+		// NOTE: The format is "pid (comm) state ppid pgrp ...", and comm can
+		// contain spaces and parentheses.
+		s := string(buf)
+		i := strings.LastIndex(s, ")")
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(s[i+1:])
+		if len(fields) < 3 || fields[0] == "Z" ||
+			fields[2] != strconv.Itoa(pgid) {
+			continue
+		}
+		if pid, err := strconv.Atoi(strings.Fields(s)[0]); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+func processGroupWithPs(pgid int) []int {
+	out, err := exec.Command(
+		"ps", "-A", "-o", "pid=", "-o", "pgid=", "-o", "stat=").Output()
+	if err != nil {
+		return nil
+	}
+	pids := []int{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || strings.HasPrefix(fields[2], "Z") ||
+			fields[1] != strconv.Itoa(pgid) {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[0]); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
@@ -0,0 +1,75 @@
+package pytest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// setProcessGroup does nothing on Windows because killProcessGroup kills a
+// process tree with taskkill.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the process tree of the command.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return exec.Command(
+		"taskkill", "/T", "/F", "/PID", fmt.Sprintf("%d", cmd.Process.Pid),
+	).Run()
+}
+
+// terminateProcessGroup kills the process tree of the command.  Windows has
+// no SIGTERM, so gracePeriod is ignored, and stray processes are not counted.
+func terminateProcessGroup(
+	cmd *exec.Cmd, gracePeriod time.Duration,
+) (int, error) {
+	return 0, killProcessGroup(cmd)
+}
+
+// dumpTraceback fails on Windows because Windows has no SIGUSR1.
+func dumpTraceback(pgid, pid int) error {
+	return fmt.Errorf("tracebacks are not supported on Windows")
+}
+
+// setResourceUsage does nothing on Windows because Windows does not report
+// the maximum resident set size and # of context switches.
+func setResourceUsage(
+	result *xpytest_proto.TestResult, state *os.ProcessState,
+) {
+}
+
+// reapProcessGroup does nothing on Windows because the process tree of the
+// command cannot be found after the command exits.
+func reapProcessGroup(
+	cmd *exec.Cmd, gracePeriod time.Duration,
+) (int, error) {
+	return 0, nil
+}
+
+// clampLimits returns opts as is because Windows has no rlimits.
+func clampLimits(opts ExecuteOptions) (ExecuteOptions, error) {
+	return opts, nil
+}
+
+// limitCommand returns args as is because Windows has no rlimits, so resource
+// limits are ignored.
+func limitCommand(args []string, opts ExecuteOptions) []string {
+	return args
+}
+
+// failedToLimit returns false because limitCommand sets no limits on Windows.
+func failedToLimit(
+	state *os.ProcessState, opts ExecuteOptions, stderr string,
+) bool {
+	return false
+}
+
+// killedByCPUTimeLimit returns false because CPU time is not limited on
+// Windows.
+func killedByCPUTimeLimit(
+	state *os.ProcessState, limit time.Duration,
+) bool {
+	return false
+}
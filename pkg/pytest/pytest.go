@@ -2,8 +2,15 @@ package pytest
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,6 +29,28 @@ type Pytest struct {
 	Retry    int
 	Env      []string
 	Deadline time.Duration
+
+	// IdleTimeout is a duration that pytest can print nothing for.  pytest is
+	// terminated as HUNG after this.  No idle timeout if this is 0.
+	IdleTimeout time.Duration
+
+	// MemoryLimitMB and CPUTimeLimit are limits of each process of pytest (see
+	// ExecuteOptions).  pytest results in LIMIT_EXCEEDED if it exceeds them.
+	// No limit if they are 0.
+	MemoryLimitMB int
+	CPUTimeLimit  time.Duration
+
+	// RetryFailedCases makes retries run only the test cases that failed
+	// instead of the whole files.  Failed test cases are recorded in a
+	// temporary cache directory of pytest.
+	RetryFailedCases bool
+
+	// RecordFailedCases records failed test cases even if Retry allows no
+	// retries (see Result.FailedCases).
+	RecordFailedCases bool
+
+	// Args are additional arguments given to pytest.
+	Args []string
 }
 
 // NewPytest creates a new Pytest object.
@@ -29,31 +58,107 @@ func NewPytest(pythonCmd string) *Pytest {
 	return &Pytest{PythonCmd: pythonCmd, Executor: Execute}
 }
 
-// Execute builds pytest parameters and runs pytest.
+// NewPytestWithQuery creates a new Pytest object to run the given test query.
+// Parameters that the query does not specify are inherited from base.
+func NewPytestWithQuery(base *Pytest, tq *xpytest_proto.TestQuery) *Pytest {
+	p := *base
+	p.Files = []string{tq.GetFile()}
+	if len(tq.GetNodeIds()) > 0 {
+		p.Files = tq.GetNodeIds()
+	}
+	p.Xdist = int(tq.GetXdist())
+	if tq.GetRetry() != 0 {
+		p.Retry = int(tq.GetRetry())
+	}
+	if tq.GetDeadline() != 0 {
+		p.Deadline = time.Duration(tq.GetDeadline()*1e6) * time.Microsecond
+	}
+	if tq.GetIdleTimeout() != 0 {
+		p.IdleTimeout =
+			time.Duration(tq.GetIdleTimeout()*1e6) * time.Microsecond
+	}
+	if tq.GetMemoryLimitMb() != 0 {
+		p.MemoryLimitMB = int(tq.GetMemoryLimitMb())
+	}
+	if tq.GetCpuTimeLimit() != 0 {
+		p.CPUTimeLimit =
+			time.Duration(tq.GetCpuTimeLimit()*1e6) * time.Microsecond
+	}
+	if len(tq.GetArgs()) > 0 {
+		p.Args = append(append([]string{}, p.Args...), tq.GetArgs()...)
+	}
+	return &p
+}
+
+// Execute builds pytest parameters and runs pytest.  If Pytest.Retry allows
+// retries, failed tests (see IsRetriable) are retried.  Only the failed test
+// cases are retried if Pytest.RetryFailedCases is true.
 func (p *Pytest) Execute(
 	ctx context.Context,
 ) (*Result, error) {
+	cacheDir := ""
+	if (p.RetryFailedCases && p.Retry > 1) || p.RecordFailedCases {
+		dir, err := ioutil.TempDir("", "xpytest-cache-")
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to create a cache directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+		cacheDir = dir
+	}
 	var finalResult *Result
 	for trial := 0; trial == 0 || trial < p.Retry; trial++ {
-		pr, err := p.execute(ctx)
+		lastFailed := finalResult != nil && len(finalResult.failedCases) > 0
+		pr, err := p.execute(ctx, cacheDir, lastFailed)
+		if ctx.Err() != nil {
+			// The test was aborted, so its result is not reliable.  A failure
+			// in a previous trial is kept because it is a real failure.
+			if finalResult == nil {
+				if pr == nil {
+					pr = NewResult(p, &xpytest_proto.TestResult{})
+				}
+				finalResult = pr
+				finalResult.Status = xpytest_proto.TestResult_NOT_RUN
+				finalResult.summary = ""
+			}
+			break
+		}
 		if err != nil {
 			return nil, err
 		}
 		if trial == 0 {
 			finalResult = pr
-		} else if pr.Status == xpytest_proto.TestResult_SUCCESS {
-			finalResult.Status = xpytest_proto.TestResult_FLAKY
+			if cacheDir != "" && IsRetriable(pr.Status) {
+				finalResult.failedCases = p.readLastFailed(cacheDir)
+			}
+		} else {
+			finalResult.reaped += pr.reaped
+			finalResult.addResourceUsage(pr)
+			if pr.Status == xpytest_proto.TestResult_SUCCESS {
+				finalResult.Status = xpytest_proto.TestResult_FLAKY
+				finalResult.flakyCases = finalResult.failedCases
+			}
 		}
 		finalResult.trial = trial
-		if finalResult.Status != xpytest_proto.TestResult_FAILED {
+		if !IsRetriable(finalResult.Status) {
 			break
 		}
 	}
 	return finalResult, nil
 }
 
+// IsRetriable returns true if a test with the status should be retried.  A
+// test exceeding its limit is not retried because it would exceed the limit
+// again and waste the resources.
+func IsRetriable(status xpytest_proto.TestResult_Status) bool {
+	return status == xpytest_proto.TestResult_FAILED
+}
+
+// execute runs pytest once.  If cacheDir is given, pytest uses it as its
+// cache directory, and it runs only test cases that failed last time if
+// lastFailed is true.
 func (p *Pytest) execute(
-	ctx context.Context,
+	ctx context.Context, cacheDir string, lastFailed bool,
 ) (*Result, error) {
 	// Build command-line arguments.
 	args := []string{p.PythonCmd, "-m", "pytest"}
@@ -63,6 +168,13 @@ func (p *Pytest) execute(
 	if p.Xdist > 0 {
 		args = append(args, "-n", fmt.Sprintf("%d", p.Xdist))
 	}
+	if cacheDir != "" {
+		args = append(args, "-o", "cache_dir="+cacheDir)
+		if lastFailed {
+			args = append(args, "--lf")
+		}
+	}
+	args = append(args, p.Args...)
 	if len(p.Files) == 0 {
 		return nil, errors.New("Pytest.Files must not be empty")
 	}
@@ -75,33 +187,156 @@ func (p *Pytest) execute(
 	}
 
 	// Execute pytest.
+	if p.IdleTimeout > 0 || p.MemoryLimitMB > 0 || p.CPUTimeLimit > 0 {
+		ctx = WithExecuteOptions(ctx, ExecuteOptions{
+			IdleTimeout:   p.IdleTimeout,
+			MemoryLimitMB: p.MemoryLimitMB,
+			CPUTimeLimit:  p.CPUTimeLimit,
+		})
+	}
 	r, err := p.Executor(ctx, args, deadline, p.Env)
 	if err != nil {
 		return nil, err
 	}
-	return newPytestResult(p, r), nil
+	return NewResult(p, r), nil
+}
+
+// readLastFailed returns node IDs of test cases that pytest recorded as failed
+// in the cache directory.  Node IDs are rewritten to start with the file path
+// as Collect does.
+func (p *Pytest) readLastFailed(cacheDir string) []string {
+	buf, err := ioutil.ReadFile(
+		filepath.Join(cacheDir, "v", "cache", "lastfailed"))
+	if err != nil {
+		return nil
+	}
+	lastFailed := map[string]bool{}
+	if err := json.Unmarshal(buf, &lastFailed); err != nil {
+		return nil
+	}
+	file := ""
+	if len(p.Files) > 0 {
+		file = p.Files[0]
+		if i := strings.Index(file, "::"); i >= 0 {
+			file = file[:i]
+		}
+	}
+	nodeIDs := []string{}
+	for id := range lastFailed {
+		i := strings.Index(id, "::")
+		if i < 0 {
+			// A key without a test case is a file that pytest failed to
+			// collect, so the whole file should run.
+			if file == "" {
+				continue
+			}
+			return []string{file}
+		}
+		if i > 0 && file != "" {
+			id = file + id[i:]
+		}
+		nodeIDs = append(nodeIDs, id)
+	}
+	sort.Strings(nodeIDs)
+	return nodeIDs
+}
+
+// Collect collects node IDs of test cases in Pytest.Files without running
+// them.  If Pytest.Files has only one file, node IDs are rewritten to start
+// with the file path because pytest prints node IDs relative to its root
+// directory, which can differ from the current directory.
+func (p *Pytest) Collect(ctx context.Context) ([]string, error) {
+	args := []string{p.PythonCmd, "-m", "pytest", "--collect-only", "-q"}
+	if p.MarkerExpression != "" {
+		args = append(args, "-m", p.MarkerExpression)
+	}
+	if len(p.Files) == 0 {
+		return nil, errors.New("Pytest.Files must not be empty")
+	}
+	args = append(args, p.Files...)
+	if p.Deadline <= 0 {
+		return nil, fmt.Errorf("Pytest.Deadline must be positive value")
+	}
+
+	r, err := p.Executor(ctx, args, p.Deadline, p.Env)
+	if err != nil {
+		return nil, err
+	}
+	if r.GetStatus() != xpytest_proto.TestResult_SUCCESS {
+		return nil, fmt.Errorf("failed to collect tests: %s: %s",
+			r.GetStatus(), strings.TrimSpace(r.GetStdout()))
+	}
+
+	// NOTE: pytest -q prints node IDs first, and then it prints other
+	// information (e.g., # of tests and warnings) after an empty line.
+	nodeIDs := []string{}
+	for _, line := range strings.Split(r.GetStdout(), "\n") {
+		line = strings.TrimSpace(line)
+		i := strings.Index(line, "::")
+		if i <= 0 {
+			break
+		}
+		if len(p.Files) == 1 {
+			line = p.Files[0] + line[i:]
+		}
+		nodeIDs = append(nodeIDs, line)
+	}
+	return nodeIDs, nil
 }
 
 // Result represents a pytest execution result.
 type Result struct {
-	Status   xpytest_proto.TestResult_Status
-	Name     string
+	Status xpytest_proto.TestResult_Status
+	Name   string
+
+	// Reason describes why the test did not run (e.g., "out of time").
+	Reason string
+
 	xdist    int
 	trial    int
+	chunks   int
 	duration float32
 	summary  string
 	stdout   string
 	stderr   string
+
+	// failedCases are node IDs of test cases failed in the first trial, and
+	// flakyCases are those passed in retries.
+	failedCases []string
+	flakyCases  []string
+
+	// reaped is # of stray processes terminated in all the trials.
+	reaped int
+
+	// tracebacks are dumped by Python processes on timeout.
+	tracebacks string
+
+	// exceededLimit is the limit that pytest exceeded if Status is
+	// LIMIT_EXCEEDED.
+	exceededLimit string
+
+	// Resource usage in all the trials.  maxRSS is the maximum, and the others
+	// are totals.
+	maxRSS              int64
+	userTime            float32
+	systemTime          float32
+	voluntarySwitches   int64
+	involuntarySwitches int64
 }
 
-func newPytestResult(p *Pytest, tr *xpytest_proto.TestResult) *Result {
+// NewResult creates a Result from a TestResult of the given pytest execution.
+func NewResult(p *Pytest, tr *xpytest_proto.TestResult) *Result {
 	r := &Result{}
 	if len(p.Files) > 0 {
 		r.Name = p.Files[0]
 	}
 	r.Status = tr.GetStatus()
 	result := ""
-	if r.Status != xpytest_proto.TestResult_TIMEOUT {
+	if r.Status != xpytest_proto.TestResult_TIMEOUT &&
+		r.Status != xpytest_proto.TestResult_HUNG &&
+		r.Status != xpytest_proto.TestResult_LIMIT_EXCEEDED &&
+		r.Status != xpytest_proto.TestResult_NOT_RUN &&
+		r.Status != xpytest_proto.TestResult_INTERRUPTED {
 		lines := strings.Split(strings.TrimSpace(tr.Stdout), "\n")
 		lastLine := lines[len(lines)-1]
 		if strings.HasPrefix(lastLine, "=") {
@@ -113,10 +348,31 @@ func newPytestResult(p *Pytest, tr *xpytest_proto.TestResult) *Result {
 	}
 	r.xdist = p.Xdist
 	r.duration = tr.GetTime()
+	r.reaped = int(tr.GetReapedProcesses())
+	r.tracebacks = tr.GetTracebacks()
+	r.exceededLimit = tr.GetExceededLimit()
+	if tr.GetTrials() > 1 {
+		r.trial = int(tr.GetTrials()) - 1
+	}
+	r.failedCases = tr.GetFailedCases()
+	r.flakyCases = tr.GetFlakyCases()
+	r.maxRSS = tr.GetMaxRss()
+	r.userTime = tr.GetUserTime()
+	r.systemTime = tr.GetSystemTime()
+	r.voluntarySwitches = tr.GetVoluntaryContextSwitches()
+	r.involuntarySwitches = tr.GetInvoluntaryContextSwitches()
 	r.summary = func() string {
 		if r.Status == xpytest_proto.TestResult_TIMEOUT {
 			return fmt.Sprintf("%.0f seconds", r.duration)
 		}
+		if r.Status == xpytest_proto.TestResult_HUNG {
+			return fmt.Sprintf("%.0f seconds, no output for %.0f seconds",
+				r.duration, p.IdleTimeout.Seconds())
+		}
+		if r.Status == xpytest_proto.TestResult_LIMIT_EXCEEDED {
+			return fmt.Sprintf("%.0f seconds, exceeded %s",
+				r.duration, r.exceededLimit)
+		}
 		return fmt.Sprintf("%s", result)
 	}()
 	shorten := func(s string) string {
@@ -135,6 +391,73 @@ func newPytestResult(p *Pytest, tr *xpytest_proto.TestResult) *Result {
 	return r
 }
 
+// TestResult returns the test result as a TestResult message.
+func (r *Result) TestResult() *xpytest_proto.TestResult {
+	trials := int32(r.trial + 1)
+	if r.Status == xpytest_proto.TestResult_NOT_RUN {
+		trials = 0
+	}
+	return &xpytest_proto.TestResult{
+		Status: r.Status,
+		Name:   r.Name,
+		Stdout: r.stdout,
+		Stderr: r.stderr,
+		Time:   r.duration,
+
+		ReapedProcesses: int32(r.reaped),
+		Tracebacks:      r.tracebacks,
+		ExceededLimit:   r.exceededLimit,
+
+		Trials:      trials,
+		FailedCases: r.failedCases,
+		FlakyCases:  r.flakyCases,
+
+		MaxRss:                     r.maxRSS,
+		UserTime:                   r.userTime,
+		SystemTime:                 r.systemTime,
+		VoluntaryContextSwitches:   r.voluntarySwitches,
+		InvoluntaryContextSwitches: r.involuntarySwitches,
+	}
+}
+
+// addResourceUsage adds resource usage of another execution of the test.
+func (r *Result) addResourceUsage(other *Result) {
+	if other.maxRSS > r.maxRSS {
+		r.maxRSS = other.maxRSS
+	}
+	r.userTime += other.userTime
+	r.systemTime += other.systemTime
+	r.voluntarySwitches += other.voluntarySwitches
+	r.involuntarySwitches += other.involuntarySwitches
+}
+
+// AddRerun updates the result of a failed test with the result of its rerun,
+// which is deferred (e.g., until other tests finish).  The test is FLAKY if
+// the rerun passes.
+func (r *Result) AddRerun(rerun *Result) {
+	if rerun.Status == xpytest_proto.TestResult_NOT_RUN ||
+		rerun.Status == xpytest_proto.TestResult_INTERRUPTED {
+		return
+	}
+	r.trial += rerun.trial + 1
+	r.reaped += rerun.reaped
+	r.addResourceUsage(rerun)
+	if rerun.Status == xpytest_proto.TestResult_SUCCESS ||
+		rerun.Status == xpytest_proto.TestResult_FLAKY {
+		r.Status = xpytest_proto.TestResult_FLAKY
+		r.flakyCases = r.failedCases
+	}
+}
+
+// FailedCases returns node IDs of test cases that failed in the first trial
+// if they are known.
+func (r *Result) FailedCases() []string {
+	return r.failedCases
+}
+
+// maxFlakyCases is # of flaky test cases that Summary shows.
+const maxFlakyCases = 5
+
 // Summary returns a one-line summary of the test result (e.g.,
 // "[SUCCESS] test_foo.py (123 passed in 4.56 seconds)").
 func (r *Result) Summary() string {
@@ -142,12 +465,36 @@ func (r *Result) Summary() string {
 	if r.summary != "" {
 		ss = append(ss, r.summary)
 	}
+	if r.Reason != "" {
+		ss = append(ss, r.Reason)
+	}
 	if r.xdist > 0 {
 		ss = append(ss, fmt.Sprintf("%d procs", r.xdist))
 	}
 	if r.trial > 0 {
 		ss = append(ss, fmt.Sprintf("%d trials", r.trial+1))
 	}
+	if r.chunks > 0 {
+		ss = append(ss, fmt.Sprintf("%d chunks", r.chunks))
+	}
+	if r.reaped > 0 {
+		ss = append(ss, fmt.Sprintf("%d stray procs reaped", r.reaped))
+	}
+	if r.maxRSS > 0 {
+		ss = append(ss, FormatBytes(r.maxRSS)+" max RSS")
+	}
+	if r.userTime > 0 || r.systemTime > 0 {
+		ss = append(ss, fmt.Sprintf(
+			"%.1fs user, %.1fs sys", r.userTime, r.systemTime))
+	}
+	if len(r.flakyCases) > 0 {
+		cases := r.flakyCases
+		if len(cases) > maxFlakyCases {
+			cases = append(cases[:maxFlakyCases:maxFlakyCases], fmt.Sprintf(
+				"and %d more", len(r.flakyCases)-maxFlakyCases))
+		}
+		ss = append(ss, "flaky: "+strings.Join(cases, ", "))
+	}
 	s := strings.Join(ss, " * ")
 	if s != "" {
 		s = " (" + s + ")"
@@ -156,11 +503,129 @@ func (r *Result) Summary() string {
 }
 
 // Output returns the test result.  This returns outputs from STDOUT/STDERR in
-// addition to a one-line summary returned by Summary.
+// addition to a one-line summary returned by Summary.  Tracebacks dumped on
+// timeout follow the outputs.
 func (r *Result) Output() string {
 	if r.Status == xpytest_proto.TestResult_SUCCESS {
 		return strings.TrimSpace(r.Summary() + "\n" + r.stderr)
 	}
-	return strings.TrimSpace(r.Summary() + "\n" +
+	s := strings.TrimSpace(r.Summary() + "\n" +
 		strings.TrimSpace(r.stdout+"\n"+r.stderr))
+	if r.tracebacks != "" {
+		s += "\n\nTracebacks on timeout:\n" + r.tracebacks
+	}
+	return s
+}
+
+// HangLocations returns where Python processes were running when the test
+// timed out if their tracebacks are dumped (e.g.,
+// `process 123: File "test_foo.py", line 4 in test_bar`).
+func (r *Result) HangLocations() []string {
+	return hangLocations(r.tracebacks)
+}
+
+// FormatBytes formats a size in bytes for humans (e.g., "1.5 GB").
+func FormatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// statusSeverity is used to choose the most severe status when results are
+// merged.
+var statusSeverity = map[xpytest_proto.TestResult_Status]int{
+	xpytest_proto.TestResult_SUCCESS:        1,
+	xpytest_proto.TestResult_FLAKY:          2,
+	xpytest_proto.TestResult_NOT_RUN:        3,
+	xpytest_proto.TestResult_INTERRUPTED:    4,
+	xpytest_proto.TestResult_FAILED:         5,
+	xpytest_proto.TestResult_LIMIT_EXCEEDED: 6,
+	xpytest_proto.TestResult_TIMEOUT:        7,
+	xpytest_proto.TestResult_HUNG:           8,
+	xpytest_proto.TestResult_INTERNAL:       9,
+}
+
+var countPattern = regexp.MustCompile(`^(\d+) (\w+)$`)
+
+// MergeResults merges results of chunks of a test into a result named name.
+// The merged result has the most severe status of the chunks, and its
+// duration is the total duration of the chunks.
+func MergeResults(name string, results []*Result) *Result {
+	r := &Result{Name: name, chunks: len(results)}
+	counts := map[string]int{}
+	countNames := []string{}
+	summaries := []string{}
+	stdouts := []string{}
+	stderrs := []string{}
+	tracebacks := []string{}
+	for _, cr := range results {
+		if statusSeverity[cr.Status] > statusSeverity[r.Status] {
+			r.Status = cr.Status
+		}
+		if r.Reason == "" {
+			r.Reason = cr.Reason
+		}
+		if cr.xdist > r.xdist {
+			r.xdist = cr.xdist
+		}
+		if cr.trial > r.trial {
+			r.trial = cr.trial
+		}
+		r.duration += cr.duration
+		r.reaped += cr.reaped
+		r.addResourceUsage(cr)
+		r.failedCases = append(r.failedCases, cr.failedCases...)
+		r.flakyCases = append(r.flakyCases, cr.flakyCases...)
+		summaries = append(summaries, cr.summary)
+		stdouts = append(stdouts, cr.stdout)
+		stderrs = append(stderrs, cr.stderr)
+		if cr.tracebacks != "" {
+			tracebacks = append(tracebacks, cr.tracebacks)
+		}
+		if r.exceededLimit == "" {
+			r.exceededLimit = cr.exceededLimit
+		}
+
+		// Sum up counts in a summary (e.g., "1 failed, 2 passed in 3.45
+		// seconds").  Summaries are just concatenated if any of them is not
+		// in this format (e.g., a summary of TIMEOUT).
+		s := cr.summary
+		if i := strings.LastIndex(s, " in "); i >= 0 {
+			s = s[:i]
+		}
+		for _, c := range strings.Split(s, ", ") {
+			m := countPattern.FindStringSubmatch(c)
+			if m == nil || counts == nil {
+				counts = nil
+				break
+			}
+			if _, ok := counts[m[2]]; !ok {
+				countNames = append(countNames, m[2])
+			}
+			n, _ := strconv.Atoi(m[1])
+			counts[m[2]] += n
+		}
+	}
+	if r.Status != xpytest_proto.TestResult_NOT_RUN {
+		r.Reason = ""
+	}
+	if counts != nil {
+		ss := []string{}
+		for _, c := range countNames {
+			ss = append(ss, fmt.Sprintf("%d %s", counts[c], c))
+		}
+		r.summary = strings.Join(ss, ", ")
+	} else {
+		r.summary = strings.Join(summaries, "; ")
+	}
+	r.stdout = strings.Join(stdouts, "\n")
+	r.stderr = strings.Join(stderrs, "\n")
+	r.tracebacks = strings.Join(tracebacks, "\n\n")
+	return r
 }
@@ -2,6 +2,9 @@ package pytest_test
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -132,6 +135,207 @@ func TestPytestWithTimeoutTest(t *testing.T) {
 	}
 }
 
+func TestPytestWithHungTest(t *testing.T) {
+	ctx := context.Background()
+	base := pytest.NewPytest("python3")
+	base.Executor = func(
+		ctx context.Context,
+		args []string, deadline time.Duration, env []string,
+	) (*xpytest_proto.TestResult, error) {
+		opts := pytest.ExecuteOptionsFromContext(ctx)
+		if opts.IdleTimeout != 30*time.Second {
+			t.Errorf("unexpected idle timeout: %s", opts.IdleTimeout)
+		}
+		return &xpytest_proto.TestResult{
+			Status: xpytest_proto.TestResult_HUNG,
+			Stdout: "....",
+			Time:   31.234,
+		}, nil
+	}
+	base.Deadline = time.Minute
+	p := pytest.NewPytestWithQuery(base, &xpytest_proto.TestQuery{
+		File:        "test_foo.py",
+		IdleTimeout: 30,
+	})
+	if r, err := p.Execute(ctx); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	} else if s := r.Summary(); s != "[HUNG] test_foo.py "+
+		"(31 seconds, no output for 30 seconds)" {
+		t.Fatalf("unexpected summary: %s", s)
+	}
+}
+
+func TestPytestWithLimitExceeded(t *testing.T) {
+	ctx := context.Background()
+	base := pytest.NewPytest("python3")
+	base.Executor = func(
+		ctx context.Context,
+		args []string, deadline time.Duration, env []string,
+	) (*xpytest_proto.TestResult, error) {
+		opts := pytest.ExecuteOptionsFromContext(ctx)
+		if opts.MemoryLimitMB != 1024 {
+			t.Errorf("unexpected memory limit: %d", opts.MemoryLimitMB)
+		}
+		if opts.CPUTimeLimit != 90*time.Second {
+			t.Errorf("unexpected CPU time limit: %s", opts.CPUTimeLimit)
+		}
+		return &xpytest_proto.TestResult{
+			Status:        xpytest_proto.TestResult_LIMIT_EXCEEDED,
+			Stdout:        "=== 1 failed in 3.21 seconds ===",
+			Time:          3.456,
+			ExceededLimit: "memory limit of 1024 MB",
+		}, nil
+	}
+	base.Deadline = time.Minute
+	base.MemoryLimitMB = 512
+	base.CPUTimeLimit = 90 * time.Second
+	p := pytest.NewPytestWithQuery(base, &xpytest_proto.TestQuery{
+		File:          "test_foo.py",
+		MemoryLimitMb: 1024,
+	})
+	r, err := p.Execute(ctx)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if s := r.Summary(); s != "[LIMIT_EXCEEDED] test_foo.py "+
+		"(3 seconds, exceeded memory limit of 1024 MB)" {
+		t.Fatalf("unexpected summary: %s", s)
+	}
+	if s := r.TestResult().ExceededLimit; s != "memory limit of 1024 MB" {
+		t.Fatalf("unexpected exceeded limit: %s", s)
+	}
+}
+
+func TestPytestWithLimitExceededNotRetried(t *testing.T) {
+	ctx := context.Background()
+	p := pytest.NewPytest("python3")
+	trial := 0
+	p.Executor = func(
+		ctx context.Context,
+		args []string, deadline time.Duration, env []string,
+	) (*xpytest_proto.TestResult, error) {
+		trial++
+		if trial == 1 {
+			return &xpytest_proto.TestResult{
+				Status:        xpytest_proto.TestResult_LIMIT_EXCEEDED,
+				Time:          3.456,
+				ExceededLimit: "memory limit of 1024 MB",
+			}, nil
+		}
+		return &xpytest_proto.TestResult{
+			Status: xpytest_proto.TestResult_SUCCESS,
+			Stdout: "=== 1 passed in 1.23 seconds ===",
+		}, nil
+	}
+	p.Files = []string{"test_foo.py"}
+	p.Deadline = time.Minute
+	p.Retry = 2
+	// A test exceeding its limit is not retried.
+	if r, err := p.Execute(ctx); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	} else if s := r.Summary(); s != "[LIMIT_EXCEEDED] test_foo.py "+
+		"(3 seconds, exceeded memory limit of 1024 MB)" {
+		t.Fatalf("unexpected summary: %s", s)
+	}
+	if trial != 1 {
+		t.Fatalf("unexpected # of trials: %d", trial)
+	}
+}
+
+func TestPytestWithStrayProcesses(t *testing.T) {
+	ctx := context.Background()
+	p := pytest.NewPytest("python3")
+	executor := &pytestExecutor{
+		TestResult: &xpytest_proto.TestResult{
+			Status:          xpytest_proto.TestResult_TIMEOUT,
+			Time:            61.234,
+			ReapedProcesses: 3,
+		},
+	}
+	p.Executor = executor.Execute
+	p.Files = []string{"test_foo.py"}
+	p.Deadline = time.Minute
+	if r, err := p.Execute(ctx); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	} else if s := r.Summary(); s !=
+		"[TIMEOUT] test_foo.py (61 seconds * 3 stray procs reaped)" {
+		t.Fatalf("unexpected summary: %s", s)
+	} else if n := r.TestResult().ReapedProcesses; n != 3 {
+		t.Fatalf("unexpected # of reaped processes: %d", n)
+	}
+}
+
+func TestPytestWithResourceUsage(t *testing.T) {
+	ctx := context.Background()
+	results := []*pytest.Result{}
+	for _, rss := range []int64{1536 << 20, 512 << 20} {
+		p := pytest.NewPytest("python3")
+		executor := &pytestExecutor{
+			TestResult: &xpytest_proto.TestResult{
+				Status:                     xpytest_proto.TestResult_SUCCESS,
+				Stdout:                     "=== 1 passed in 1.23 seconds ===",
+				MaxRss:                     rss,
+				UserTime:                   12.0,
+				SystemTime:                 0.5,
+				VoluntaryContextSwitches:   10,
+				InvoluntaryContextSwitches: 2,
+			},
+		}
+		p.Executor = executor.Execute
+		p.Files = []string{"test_foo.py"}
+		p.Deadline = time.Minute
+		r, err := p.Execute(ctx)
+		if err != nil {
+			t.Fatalf("failed to execute: %s", err)
+		}
+		results = append(results, r)
+	}
+	if s := results[0].Summary(); s != "[SUCCESS] test_foo.py "+
+		"(1 passed in 1.23 seconds * 1.5 GB max RSS * 12.0s user, 0.5s sys)" {
+		t.Fatalf("unexpected summary: %s", s)
+	}
+	r := pytest.MergeResults("test_foo.py", results).TestResult()
+	if r.MaxRss != 1536<<20 || r.UserTime != 24.0 || r.SystemTime != 1.0 ||
+		r.VoluntaryContextSwitches != 20 ||
+		r.InvoluntaryContextSwitches != 4 {
+		t.Fatalf("unexpected resource usage: %s", r)
+	}
+}
+
+func TestPytestWithTracebacks(t *testing.T) {
+	ctx := context.Background()
+	p := pytest.NewPytest("python3")
+	tracebacks := "Process 123:\n" +
+		"Thread 0x0002 (most recent call first):\n" +
+		"  File \"threading.py\", line 306 in wait\n" +
+		"\n" +
+		"Current thread 0x0001 (most recent call first):\n" +
+		"  File \"test_foo.py\", line 4 in test_bar\n" +
+		"  File \"python.py\", line 5 in pytest_pyfunc_call"
+	executor := &pytestExecutor{
+		TestResult: &xpytest_proto.TestResult{
+			Status:     xpytest_proto.TestResult_TIMEOUT,
+			Time:       61.234,
+			Tracebacks: tracebacks,
+		},
+	}
+	p.Executor = executor.Execute
+	p.Files = []string{"test_foo.py"}
+	p.Deadline = time.Minute
+	r, err := p.Execute(ctx)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if s := strings.Join(r.HangLocations(), "\n"); s !=
+		`process 123: File "test_foo.py", line 4 in test_bar` {
+		t.Fatalf("unexpected hang locations: %s", s)
+	}
+	if s := r.Output(); s != "[TIMEOUT] test_foo.py (61 seconds)\n\n"+
+		"Tracebacks on timeout:\n"+tracebacks {
+		t.Fatalf("unexpected output: %s", s)
+	}
+}
+
 func TestPytestWithOutput(t *testing.T) {
 	ctx := context.Background()
 	p := pytest.NewPytest("python3")
@@ -182,3 +386,250 @@ func TestPytestWithLongOutput(t *testing.T) {
 		t.Fatalf("unexpected output: %d: %s", len(ss), ss)
 	}
 }
+
+func TestPytestCollect(t *testing.T) {
+	ctx := context.Background()
+	p := pytest.NewPytest("python3")
+	executor := &pytestExecutor{
+		TestResult: &xpytest_proto.TestResult{
+			Status: xpytest_proto.TestResult_SUCCESS,
+			Stdout: "foo/test_foo.py::test_a\n" +
+				"foo/test_foo.py::TestFoo::test_b[1-2]\n" +
+				"\n2 tests collected in 0.12 seconds\n",
+		},
+	}
+	p.Executor = executor.Execute
+	p.Files = []string{"test_foo.py"}
+	p.Deadline = time.Minute
+	if nodeIDs, err := p.Collect(ctx); err != nil {
+		t.Fatalf("failed to collect: %s", err)
+	} else if strings.Join(executor.Args, ",") !=
+		"python3,-m,pytest,--collect-only,-q,test_foo.py" {
+		t.Fatalf("unexpected args: %s", executor.Args)
+	} else if s := strings.Join(nodeIDs, ","); s !=
+		"test_foo.py::test_a,test_foo.py::TestFoo::test_b[1-2]" {
+		t.Fatalf("unexpected node IDs: %s", s)
+	}
+}
+
+func TestMergeResults(t *testing.T) {
+	ctx := context.Background()
+	results := []*pytest.Result{}
+	for _, stdout := range []string{
+		"=== 1 failed, 2 passed in 1.23 seconds ===",
+		"=== 3 passed, 1 skipped in 4.56 seconds ===",
+	} {
+		p := pytest.NewPytest("python3")
+		executor := &pytestExecutor{
+			TestResult: &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_FAILED,
+				Stdout: stdout,
+			},
+		}
+		if len(results) > 0 {
+			executor.TestResult.Status = xpytest_proto.TestResult_SUCCESS
+		}
+		p.Executor = executor.Execute
+		p.Files = []string{"test_foo.py::test_bar"}
+		p.Deadline = time.Minute
+		r, err := p.Execute(ctx)
+		if err != nil {
+			t.Fatalf("failed to execute: %s", err)
+		}
+		results = append(results, r)
+	}
+	if s := pytest.MergeResults("test_foo.py", results).Summary(); s !=
+		"[FAILED] test_foo.py (1 failed, 5 passed, 1 skipped * 2 chunks)" {
+		t.Fatalf("unexpected summary: %s", s)
+	}
+}
+
+func TestPytestWithCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := pytest.NewPytest("python3")
+	p.Executor = func(
+		ctx context.Context,
+		args []string, deadline time.Duration, env []string,
+	) (*xpytest_proto.TestResult, error) {
+		cancel()
+		return &xpytest_proto.TestResult{
+			Status: xpytest_proto.TestResult_FAILED,
+			Stdout: "=== 1 failed in 1.23 seconds ===",
+		}, nil
+	}
+	p.Files = []string{"test_foo.py"}
+	p.Deadline = time.Minute
+	p.Retry = 2
+	if r, err := p.Execute(ctx); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	} else if r.Reason = "out of time"; r.Summary() !=
+		"[NOT_RUN] test_foo.py (out of time)" {
+		t.Fatalf("unexpected summary: %s", r.Summary())
+	}
+}
+
+func TestPytestWithCancellationInRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := pytest.NewPytest("python3")
+	trial := 0
+	p.Executor = func(
+		ctx context.Context,
+		args []string, deadline time.Duration, env []string,
+	) (*xpytest_proto.TestResult, error) {
+		trial++
+		if trial > 1 {
+			cancel()
+		}
+		return &xpytest_proto.TestResult{
+			Status: xpytest_proto.TestResult_FAILED,
+			Stdout: "=== 1 failed in 1.23 seconds ===",
+		}, nil
+	}
+	p.Files = []string{"test_foo.py"}
+	p.Deadline = time.Minute
+	p.Retry = 2
+	// The failure of the first trial is kept.
+	if r, err := p.Execute(ctx); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	} else if s := r.Summary(); s !=
+		"[FAILED] test_foo.py (1 failed in 1.23 seconds)" {
+		t.Fatalf("unexpected summary: %s", s)
+	}
+}
+
+func TestPytestWithFailedCases(t *testing.T) {
+	ctx := context.Background()
+	p := pytest.NewPytest("python3")
+	trial := 0
+	p.Executor = func(
+		ctx context.Context,
+		args []string, deadline time.Duration, env []string,
+	) (*xpytest_proto.TestResult, error) {
+		trial++
+		cacheDir := ""
+		for _, a := range args {
+			if strings.HasPrefix(a, "cache_dir=") {
+				cacheDir = strings.TrimPrefix(a, "cache_dir=")
+			}
+		}
+		if cacheDir == "" {
+			t.Fatalf("no cache directory is given: %s", args)
+		}
+		if trial == 1 {
+			// pytest records node IDs relative to its root directory.
+			dir := filepath.Join(cacheDir, "v", "cache")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatalf("failed to create a directory: %s", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "lastfailed"),
+				[]byte(`{"tests/test_foo.py::test_b[1]": true, `+
+					`"tests/test_foo.py::test_a": true}`), 0644); err != nil {
+				t.Fatalf("failed to write a file: %s", err)
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_FAILED,
+				Stdout: "=== 2 failed, 121 passed in 1.23 seconds ===",
+			}, nil
+		}
+		if s := strings.Join(args[len(args)-2:], ","); s !=
+			"--lf,test_foo.py" {
+			t.Fatalf("unexpected args: %s", args)
+		}
+		return &xpytest_proto.TestResult{
+			Status: xpytest_proto.TestResult_SUCCESS,
+			Stdout: "=== 2 passed in 0.12 seconds ===",
+		}, nil
+	}
+	p.Files = []string{"test_foo.py"}
+	p.Deadline = time.Minute
+	p.Retry = 2
+	p.RetryFailedCases = true
+	r, err := p.Execute(ctx)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	expected := "[FLAKY] test_foo.py (2 failed, 121 passed in 1.23 seconds" +
+		" * 2 trials * flaky: test_foo.py::test_a, test_foo.py::test_b[1])"
+	if s := r.Summary(); s != expected {
+		t.Fatalf("unexpected summary: %s", s)
+	}
+	// A result rebuilt from its message (e.g., on a coordinator) keeps the
+	// trials and the flaky test cases.
+	if s := pytest.NewResult(p, r.TestResult()).Summary(); s != expected {
+		t.Fatalf("unexpected summary of a rebuilt result: %s", s)
+	}
+}
+
+func TestPytestWithCollectionError(t *testing.T) {
+	ctx := context.Background()
+	p := pytest.NewPytest("python3")
+	trial := 0
+	p.Executor = func(
+		ctx context.Context,
+		args []string, deadline time.Duration, env []string,
+	) (*xpytest_proto.TestResult, error) {
+		trial++
+		if trial == 1 {
+			cacheDir := ""
+			for _, a := range args {
+				if strings.HasPrefix(a, "cache_dir=") {
+					cacheDir = strings.TrimPrefix(a, "cache_dir=")
+				}
+			}
+			// pytest records a file failing to be collected relative to its
+			// root directory.
+			dir := filepath.Join(cacheDir, "v", "cache")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatalf("failed to create a directory: %s", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "lastfailed"),
+				[]byte(`{"tests/test_foo.py": true}`), 0644); err != nil {
+				t.Fatalf("failed to write a file: %s", err)
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_FAILED,
+				Stdout: "=== 1 error in 0.12 seconds ===",
+			}, nil
+		}
+		return &xpytest_proto.TestResult{
+			Status: xpytest_proto.TestResult_SUCCESS,
+			Stdout: "=== 3 passed in 0.12 seconds ===",
+		}, nil
+	}
+	p.Files = []string{"test_foo.py"}
+	p.Deadline = time.Minute
+	p.Retry = 2
+	p.RetryFailedCases = true
+	if r, err := p.Execute(ctx); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	} else if s := strings.Join(r.FailedCases(), ","); s != "test_foo.py" {
+		t.Fatalf("unexpected failed cases: %s", s)
+	}
+}
+
+func TestPytestWithRetryOfWholeFile(t *testing.T) {
+	ctx := context.Background()
+	p := pytest.NewPytest("python3")
+	calls := []string{}
+	p.Executor = func(
+		ctx context.Context,
+		args []string, deadline time.Duration, env []string,
+	) (*xpytest_proto.TestResult, error) {
+		calls = append(calls, strings.Join(args[3:], " "))
+		return &xpytest_proto.TestResult{
+			Status: xpytest_proto.TestResult_FAILED,
+			Stdout: "=== 1 failed in 0.12 seconds ===",
+		}, nil
+	}
+	p.Files = []string{"test_foo.py"}
+	p.Deadline = time.Minute
+	p.Retry = 2
+	if _, err := p.Execute(ctx); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	// pytest runs without a temporary cache directory unless failed test
+	// cases are retried.
+	if s := strings.Join(calls, ","); s != "test_foo.py,test_foo.py" {
+		t.Fatalf("unexpected calls: %s", s)
+	}
+}
@@ -0,0 +1,285 @@
+package pytest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tracebackTimeout is a duration to wait for Python processes to dump their
+// tracebacks.
+const tracebackTimeout = time.Second
+
+// tracebackBootstrap is sitecustomize.py that makes Python processes dump
+// tracebacks of all their threads when they receive SIGUSR1 if
+// XPYTEST_DUMP_TRACEBACKS is set.  Each process writes them to a file named
+// after its PID in XPYTEST_TRACEBACK_DIR.  It also records limits that the
+// processes exceed (i.e., an uncaught MemoryError if XPYTEST_MEMORY_LIMIT is
+// set, or SIGXCPU if XPYTEST_CPU_TIME_LIMIT is set) as marker files (e.g.,
+// "memory.limit") in the directory.  The original sitecustomize module is
+// loaded after this if any.
+const tracebackBootstrap = `# Generated by xpytest to dump tracebacks.
+import os
+import sys
+
+
+def _xpytest_record_limit(name):
+    d = os.environ.get('XPYTEST_TRACEBACK_DIR')
+    if d:
+        path = os.path.join(d, name + '.limit')
+        os.close(os.open(path, os.O_WRONLY | os.O_CREAT, 0o644))
+
+
+def _xpytest_watch_limits():
+    import signal
+    excepthook = sys.excepthook
+    memory = os.environ.get('XPYTEST_MEMORY_LIMIT')
+    cpu = os.environ.get('XPYTEST_CPU_TIME_LIMIT')
+
+    def hook(exc_type, value, tb):
+        if issubclass(exc_type, MemoryError):
+            _xpytest_record_limit('memory')
+        excepthook(exc_type, value, tb)
+
+    def on_cpu_limit(signum, frame):
+        _xpytest_record_limit('cpu')
+        signal.signal(signum, signal.SIG_DFL)
+        os.kill(os.getpid(), signum)
+
+    if memory:
+        sys._xpytest_record_limit = _xpytest_record_limit
+        sys.excepthook = hook
+    if cpu and hasattr(signal, 'SIGXCPU'):
+        signal.signal(signal.SIGXCPU, on_cpu_limit)
+
+
+def _xpytest_register():
+    import faulthandler
+    import signal
+    d = os.environ.get('XPYTEST_TRACEBACK_DIR')
+    if (not d or not os.environ.get('XPYTEST_DUMP_TRACEBACKS') or
+            not hasattr(faulthandler, 'register')):
+        return
+    # Chain an existing handler, but not the default one, which would kill
+    # the process.
+    previous = signal.getsignal(signal.SIGUSR1)
+    f = open(os.path.join(d, '%d.txt' % os.getpid()), 'w')
+    faulthandler.register(
+        signal.SIGUSR1, file=f, all_threads=True,
+        chain=previous not in (signal.SIG_DFL, signal.SIG_IGN))
+    sys._xpytest_traceback_file = f
+
+
+def _xpytest_chain():
+    d = os.path.dirname(os.path.abspath(__file__))
+    sys.path[:] = [p for p in sys.path if os.path.abspath(p or '.') != d]
+    bootstrap = sys.modules.pop('sitecustomize')
+    try:
+        import sitecustomize  # NOQA
+    except ImportError:
+        # The import system requires the module being imported.
+        sys.modules['sitecustomize'] = bootstrap
+
+
+for _xpytest_setup in (_xpytest_register, _xpytest_watch_limits):
+    try:
+        _xpytest_setup()
+    except Exception:
+        pass
+_xpytest_chain()
+`
+
+// limitPlugin is a pytest plugin that records a MemoryError raised by a test
+// case as tracebackBootstrap does because pytest catches it.
+const limitPlugin = `# Generated by xpytest to record exceeded limits.
+import sys
+
+
+def pytest_runtest_makereport(item, call):
+    if call.excinfo is not None and call.excinfo.errisinstance(MemoryError):
+        record = getattr(sys, '_xpytest_record_limit', None)
+        if record is not None:
+            record('memory')
+`
+
+// tracebackDumper collects tracebacks and exceeded limits from Python
+// processes of a command.
+type tracebackDumper struct {
+	dir string
+
+	// tracebacks is true if the processes should dump their tracebacks.
+	tracebacks bool
+
+	// opts are the options of the command, whose limits are watched.
+	opts ExecuteOptions
+}
+
+// newTracebackDumper creates a tracebackDumper with a temporary directory,
+// which should be removed by close.  The processes dump their tracebacks only
+// if tracebacks is true, and only the limits set in opts are watched.
+func newTracebackDumper(
+	tracebacks bool, opts ExecuteOptions,
+) (*tracebackDumper, error) {
+	dir, err := ioutil.TempDir("", "xpytest-traceback-")
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to create a traceback directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sitecustomize.py"),
+		[]byte(tracebackBootstrap), 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to write a bootstrap: %s", err)
+	}
+	d := &tracebackDumper{dir: dir, tracebacks: tracebacks, opts: opts}
+	if opts.MemoryLimitMB <= 0 {
+		return d, nil
+	}
+	// NOTE: The plugin is in a subdirectory because the bootstrap removes its
+	// directory from sys.path.
+	if err := os.Mkdir(filepath.Join(dir, "plugin"), 0755); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create a plugin directory: %s", err)
+	}
+	if err := ioutil.WriteFile(
+		filepath.Join(dir, "plugin", "_xpytest_limit.py"),
+		[]byte(limitPlugin), 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to write a plugin: %s", err)
+	}
+	return d, nil
+}
+
+// env returns environment variables that load the bootstrap and the plugin if
+// the memory limit is set.  They are prepended to PYTHONPATH in env, and the
+// plugin is appended to PYTEST_PLUGINS.
+func (d *tracebackDumper) env(env []string) []string {
+	flag := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return ""
+	}
+	memory := d.opts.MemoryLimitMB > 0
+	paths := d.dir
+	plugins := "_xpytest_limit"
+	if memory {
+		paths += string(os.PathListSeparator) + filepath.Join(d.dir, "plugin")
+	}
+	pythonPath := paths
+	for _, e := range env {
+		if strings.HasPrefix(e, "PYTHONPATH=") && e != "PYTHONPATH=" {
+			pythonPath = paths + string(os.PathListSeparator) +
+				strings.TrimPrefix(e, "PYTHONPATH=")
+		}
+		if strings.HasPrefix(e, "PYTEST_PLUGINS=") &&
+			e != "PYTEST_PLUGINS=" {
+			plugins = strings.TrimPrefix(e, "PYTEST_PLUGINS=") +
+				",_xpytest_limit"
+		}
+	}
+	env = append(env, "PYTHONPATH="+pythonPath,
+		"XPYTEST_TRACEBACK_DIR="+d.dir,
+		"XPYTEST_DUMP_TRACEBACKS="+flag(d.tracebacks),
+		"XPYTEST_MEMORY_LIMIT="+flag(memory),
+		"XPYTEST_CPU_TIME_LIMIT="+flag(d.opts.CPUTimeLimit > 0))
+	if memory {
+		env = append(env, "PYTEST_PLUGINS="+plugins)
+	}
+	return env
+}
+
+// dump makes the Python processes in the process group dump their
+// tracebacks, and it returns the tracebacks of the processes (e.g.,
+// "Process 123:\nCurrent thread ...").
+func (d *tracebackDumper) dump(pgid int) string {
+	files, _ := filepath.Glob(filepath.Join(d.dir, "*.txt"))
+	pids := []int{}
+	for _, f := range files {
+		pid, err := strconv.Atoi(
+			strings.TrimSuffix(filepath.Base(f), ".txt"))
+		if err != nil {
+			continue
+		}
+		if err := dumpTraceback(pgid, pid); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	if len(pids) == 0 {
+		return ""
+	}
+	sort.Ints(pids)
+
+	// Wait until all the processes dump or the timeout passes.
+	read := func() map[int]string {
+		tracebacks := map[int]string{}
+		for _, pid := range pids {
+			buf, err := ioutil.ReadFile(
+				filepath.Join(d.dir, fmt.Sprintf("%d.txt", pid)))
+			if err == nil && len(buf) > 0 {
+				tracebacks[pid] = string(buf)
+			}
+		}
+		return tracebacks
+	}
+	timeout := time.Now().Add(tracebackTimeout)
+	tracebacks := read()
+	for len(tracebacks) < len(pids) && time.Now().Before(timeout) {
+		time.Sleep(50 * time.Millisecond)
+		tracebacks = read()
+	}
+	// Read again in case the processes are still writing.
+	time.Sleep(50 * time.Millisecond)
+	tracebacks = read()
+
+	ss := []string{}
+	for _, pid := range pids {
+		if tb, ok := tracebacks[pid]; ok {
+			ss = append(ss, fmt.Sprintf("Process %d:\n%s",
+				pid, strings.TrimRight(tb, "\n")))
+		}
+	}
+	return strings.Join(ss, "\n\n")
+}
+
+// exceededLimits returns names of limits that the Python processes recorded
+// as exceeded (e.g., "memory" and "cpu").
+func (d *tracebackDumper) exceededLimits() map[string]bool {
+	files, _ := filepath.Glob(filepath.Join(d.dir, "*.limit"))
+	limits := map[string]bool{}
+	for _, f := range files {
+		limits[strings.TrimSuffix(filepath.Base(f), ".limit")] = true
+	}
+	return limits
+}
+
+func (d *tracebackDumper) close() {
+	os.RemoveAll(d.dir)
+}
+
+// hangLocations returns the innermost frame of the current thread of each
+// process in tracebacks returned by tracebackDumper.dump (e.g.,
+// `process 123: File "test_foo.py", line 4 in test_bar`).
+func hangLocations(tracebacks string) []string {
+	locations := []string{}
+	process := ""
+	current := false
+	for _, line := range strings.Split(tracebacks, "\n") {
+		switch {
+		case strings.HasPrefix(line, "Process "):
+			process = strings.ToLower(strings.TrimSuffix(line, ":"))
+			current = false
+		case strings.HasPrefix(line, "Current thread "):
+			current = true
+		case current && strings.HasPrefix(strings.TrimSpace(line), "File "):
+			locations = append(locations,
+				process+": "+strings.TrimSpace(line))
+			current = false
+		}
+	}
+	return locations
+}
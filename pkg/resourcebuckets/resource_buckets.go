@@ -1,66 +1,340 @@
 package resourcebuckets
 
-import "sync"
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
 
 // ResourceBuckets manages resource capacities.  This enables worker threads to
-// use limited resources without exceeding their capacities.
+// use limited resources without exceeding their capacities.  Each bucket can
+// have multiple dimensions of resources (e.g., CPU slots and memory), and a
+// usage is acquired only if every dimension fits in a bucket.  A usage can
+// also be acquired from multiple buckets at once (e.g., for a test using
+// multiple GPUs), and then every bucket provides the usage.
+//
+// A usage that cannot be acquired for now can reserve a bucket.  While the
+// reservation is active, other usages can be acquired from the bucket only if
+// they are expected to end before the reserved usage fits (i.e., backfilling),
+// so a large usage is not starved by small usages.
 type ResourceBuckets struct {
-	buckets    []int
-	nextBucket int
-	cond       *sync.Cond
+	buckets     [][]int
+	usages      map[*ResourceUsage]struct{}
+	reservation *Reservation
+	cond        *sync.Cond
+	released    chan struct{}
 }
 
 // ResourceUsage represents a usage of resource.
 type ResourceUsage struct {
-	Index int
-	Usage int
+	// Index is the first index of Indexes.
+	Index   int
+	Indexes []int
+	Usage   []int
+
+	// End is the time when the usage is expected to be released.  The zero
+	// value means that it is unknown.
+	End time.Time
+}
+
+// Reservation represents buckets reserved for a usage.
+type Reservation struct {
+	Indexes []int
+	Usage   []int
+
+	// Shadow is the time when the usage is expected to fit in the buckets.
+	// The zero value means that it is unknown.
+	Shadow time.Time
 }
 
 // NewResourceBuckets creates a new ResourceBuckets with buckets, each of which
 // has the same size of capacity.
 func NewResourceBuckets(size, capacityPerBucket int) *ResourceBuckets {
-	buckets := make([]int, size)
-	for i := range buckets {
-		buckets[i] = capacityPerBucket
+	capacities := make([]int, size)
+	for i := range capacities {
+		capacities[i] = capacityPerBucket
+	}
+	return NewResourceBucketsWithCapacities(capacities)
+}
+
+// NewResourceBucketsWithCapacities creates a new ResourceBuckets with buckets
+// of the given capacities.
+func NewResourceBucketsWithCapacities(capacities []int) *ResourceBuckets {
+	vectors := [][]int{}
+	for _, c := range capacities {
+		vectors = append(vectors, []int{c})
+	}
+	return NewMultiResourceBuckets(vectors)
+}
+
+// NewMultiResourceBuckets creates a new ResourceBuckets with buckets of the
+// given capacity vectors.  All the vectors should have the same length.
+func NewMultiResourceBuckets(capacities [][]int) *ResourceBuckets {
+	buckets := [][]int{}
+	for _, c := range capacities {
+		buckets = append(buckets, append([]int{}, c...))
 	}
 	return &ResourceBuckets{
-		buckets: buckets,
-		cond:    sync.NewCond(&sync.Mutex{}),
+		buckets:  buckets,
+		usages:   map[*ResourceUsage]struct{}{},
+		cond:     sync.NewCond(&sync.Mutex{}),
+		released: make(chan struct{}, 1),
 	}
 }
 
-// Acquire acquires the given size of usage.  This function blocks until the
-// size of usage can be acquired from the resources.
+// Acquire acquires the given size of usage in the first dimension.  This
+// function blocks until the size of usage can be acquired from the resources.
 func (rb *ResourceBuckets) Acquire(usage int) *ResourceUsage {
+	return rb.AcquireMulti([]int{usage})
+}
+
+// AcquireMulti acquires the given usage vector from one bucket.  Dimensions
+// that the vector omits are not used.  This function blocks until every
+// dimension of the usage fits in a bucket.  If multiple buckets can accept the
+// usage, the bucket that has the most in the first dimension is chosen.
+func (rb *ResourceBuckets) AcquireMulti(usage []int) *ResourceUsage {
 	rb.cond.L.Lock()
 	defer rb.cond.L.Unlock()
-	for rb.buckets[rb.nextBucket] < usage {
+	ru := rb.acquire(1, usage, time.Time{}, true)
+	for ru == nil {
 		rb.cond.Wait()
+		ru = rb.acquire(1, usage, time.Time{}, true)
+	}
+	return ru
+}
+
+// TryAcquire acquires the given usage vector from each of k buckets without
+// blocking.  The usage is expected to be released at end.  This returns nil
+// if the usage does not fit in k buckets for now or if it may delay the
+// reservation.
+func (rb *ResourceBuckets) TryAcquire(
+	k int, usage []int, end time.Time,
+) *ResourceUsage {
+	rb.cond.L.Lock()
+	defer rb.cond.L.Unlock()
+	return rb.acquire(k, usage, end, true)
+}
+
+// Reserve reserves k buckets where the given usage is expected to fit the
+// earliest.  This replaces the existing reservation if any.  This returns nil
+// if the usage never fits in k buckets.
+func (rb *ResourceBuckets) Reserve(k int, usage []int) *Reservation {
+	rb.cond.L.Lock()
+	defer rb.cond.L.Unlock()
+	now := time.Now()
+	type candidate struct {
+		index  int
+		shadow time.Time
+	}
+	candidates := []candidate{}
+	for i := range rb.buckets {
+		if shadow, ok := rb.shadowTime(i, usage, now); ok {
+			candidates = append(candidates, candidate{i, shadow})
+		}
+	}
+	if k <= 0 || len(candidates) < k {
+		rb.reservation = nil
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i].shadow, candidates[j].shadow
+		if a.IsZero() != b.IsZero() {
+			return !a.IsZero()
+		}
+		return a.Before(b)
+	})
+	r := &Reservation{Usage: usage, Shadow: candidates[k-1].shadow}
+	for _, c := range candidates[:k] {
+		r.Indexes = append(r.Indexes, c.index)
+	}
+	rb.reservation = r
+	return r
+}
+
+// TryAcquireReserved acquires the usage of the given reservation without
+// blocking, and it cancels the reservation on success.  The usage can be
+// acquired from any bucket.
+func (rb *ResourceBuckets) TryAcquireReserved(
+	r *Reservation, end time.Time,
+) *ResourceUsage {
+	rb.cond.L.Lock()
+	defer rb.cond.L.Unlock()
+	ru := rb.acquire(len(r.Indexes), r.Usage, end, false)
+	if ru != nil && rb.reservation == r {
+		rb.reservation = nil
 	}
-	ru := &ResourceUsage{Index: rb.nextBucket, Usage: usage}
-	rb.buckets[ru.Index] -= ru.Usage
-	rb.setNextBucket()
 	return ru
 }
 
+// Cancel cancels the given reservation if it is still active.
+func (rb *ResourceBuckets) Cancel(r *Reservation) {
+	rb.cond.L.Lock()
+	defer rb.cond.L.Unlock()
+	if rb.reservation == r {
+		rb.reservation = nil
+	}
+}
+
 // Release releases the given resource usage.
 func (rb *ResourceBuckets) Release(ru *ResourceUsage) {
 	rb.cond.L.Lock()
 	defer rb.cond.L.Unlock()
-	rb.buckets[ru.Index] += ru.Usage
-	ru.Usage = 0
-	rb.setNextBucket()
+	for _, i := range ru.Indexes {
+		add(rb.buckets[i], ru.Usage)
+	}
+	ru.Usage = nil
+	delete(rb.usages, ru)
 	rb.cond.Broadcast()
+	select {
+	case rb.released <- struct{}{}:
+	default:
+	}
 }
 
-// setNextBucket recalculates rb.nextBucket.
+// Wait blocks until a usage is released after the last call of Wait or until
+// ctx is done.
+func (rb *ResourceBuckets) Wait(ctx context.Context) {
+	select {
+	case <-rb.released:
+	case <-ctx.Done():
+	}
+}
+
+// acquire acquires the given usage from each of k buckets if possible.  The
+// buckets that have the most in the first dimension are chosen.  If reserved
+// is true, the usage is not acquired from the reserved buckets when it may
+// delay the reservation.
 // CAVEAT: rb.cond.L must be locked when this is called.
-func (rb *ResourceBuckets) setNextBucket() {
-	nextBucket := 0
+func (rb *ResourceBuckets) acquire(
+	k int, usage []int, end time.Time, reserved bool,
+) *ResourceUsage {
+	now := time.Now()
+	indexes := []int{}
 	for i, b := range rb.buckets {
-		if rb.buckets[nextBucket] < b {
-			nextBucket = i
+		if !fits(b, usage) {
+			continue
+		}
+		if reserved && rb.reservation != nil &&
+			containsIndex(rb.reservation.Indexes, i) &&
+			!rb.canBackfill(i, usage, end, now) {
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+	if k <= 0 || len(indexes) < k {
+		return nil
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return rb.buckets[indexes[i]][0] > rb.buckets[indexes[j]][0]
+	})
+	ru := &ResourceUsage{
+		Index:   indexes[0],
+		Indexes: indexes[:k],
+		Usage:   append([]int{}, usage...),
+		End:     end,
+	}
+	for _, i := range ru.Indexes {
+		subtract(rb.buckets[i], ru.Usage)
+	}
+	rb.usages[ru] = struct{}{}
+	return ru
+}
+
+// canBackfill returns true if the given usage can be acquired from the index-th
+// bucket, which is reserved, without delaying the reservation, i.e., the
+// reserved usage still fits in the bucket at the shadow time.
+// CAVEAT: rb.cond.L must be locked when this is called.
+func (rb *ResourceBuckets) canBackfill(
+	index int, usage []int, end time.Time, now time.Time,
+) bool {
+	r := rb.reservation
+	free := append([]int{}, rb.buckets[index]...)
+	subtract(free, usage)
+	endsBeforeShadow := func(e time.Time) bool {
+		if e.IsZero() {
+			return false
+		}
+		if e.Before(now) {
+			e = now
+		}
+		return r.Shadow.IsZero() || !e.After(r.Shadow)
+	}
+	for ru := range rb.usages {
+		if containsIndex(ru.Indexes, index) && endsBeforeShadow(ru.End) {
+			add(free, ru.Usage)
 		}
 	}
-	rb.nextBucket = nextBucket
+	if endsBeforeShadow(end) {
+		add(free, usage)
+	}
+	return fits(free, r.Usage)
+}
+
+// shadowTime returns the time when the given usage is expected to fit in the
+// bucket.  The zero time is returned if it is unknown, and false is returned
+// if the usage never fits in the bucket.
+// CAVEAT: rb.cond.L must be locked when this is called.
+func (rb *ResourceBuckets) shadowTime(
+	index int, usage []int, now time.Time,
+) (time.Time, bool) {
+	free := append([]int{}, rb.buckets[index]...)
+	if fits(free, usage) {
+		return now, true
+	}
+	usages := []*ResourceUsage{}
+	for ru := range rb.usages {
+		if containsIndex(ru.Indexes, index) {
+			usages = append(usages, ru)
+		}
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].End.IsZero() != usages[j].End.IsZero() {
+			return !usages[i].End.IsZero()
+		}
+		return usages[i].End.Before(usages[j].End)
+	})
+	for _, ru := range usages {
+		add(free, ru.Usage)
+		if fits(free, usage) {
+			if ru.End.IsZero() {
+				return time.Time{}, true
+			}
+			if ru.End.Before(now) {
+				return now, true
+			}
+			return ru.End, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func containsIndex(indexes []int, index int) bool {
+	for _, i := range indexes {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+func fits(free, usage []int) bool {
+	for d, u := range usage {
+		if free[d] < u {
+			return false
+		}
+	}
+	return true
+}
+
+func add(x, y []int) {
+	for d := range y {
+		x[d] += y[d]
+	}
+}
+
+func subtract(x, y []int) {
+	for d := range y {
+		x[d] -= y[d]
+	}
 }
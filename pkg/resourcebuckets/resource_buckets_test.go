@@ -1,6 +1,7 @@
 package resourcebuckets_test
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -61,3 +62,117 @@ func TestResourceBucketsIndexes(t *testing.T) {
 		}
 	}
 }
+
+func TestResourceBucketsWithCapacities(t *testing.T) {
+	rb := resourcebuckets.NewResourceBucketsWithCapacities([]int{2, 5})
+	indexes := []int{}
+	for i := 0; i < 7; i++ {
+		indexes = append(indexes, rb.Acquire(1).Index)
+	}
+	if s := fmt.Sprint(indexes); s != "[1 1 1 0 1 0 1]" {
+		t.Fatalf("unexpected indexes: %s", s)
+	}
+}
+
+func TestMultiResourceBuckets(t *testing.T) {
+	rb := resourcebuckets.NewMultiResourceBuckets([][]int{{4, 100}, {2, 300}})
+	indexes := []int{}
+	// The first bucket has more in the first dimension, but only the second
+	// bucket has enough in the second dimension.
+	indexes = append(indexes, rb.AcquireMulti([]int{1, 200}).Index)
+	indexes = append(indexes, rb.AcquireMulti([]int{1, 50}).Index)
+	indexes = append(indexes, rb.AcquireMulti([]int{1, 50}).Index)
+	ru := rb.AcquireMulti([]int{1, 100})
+	indexes = append(indexes, ru.Index)
+	if s := fmt.Sprint(indexes); s != "[1 0 0 1]" {
+		t.Fatalf("unexpected indexes: %s", s)
+	}
+
+	// No bucket has enough in the second dimension until ru is released.
+	acquired := make(chan int)
+	go func() { acquired <- rb.AcquireMulti([]int{1, 100}).Index }()
+	select {
+	case i := <-acquired:
+		t.Fatalf("usage exceeding capacity is acquired: %d", i)
+	case <-time.After(100 * time.Millisecond):
+	}
+	rb.Release(ru)
+	if i := <-acquired; i != 1 {
+		t.Fatalf("unexpected index: %d", i)
+	}
+}
+
+func TestResourceBucketsWithReservation(t *testing.T) {
+	rb := resourcebuckets.NewResourceBuckets(1, 4)
+	now := time.Now()
+	long := rb.TryAcquire(1, []int{2}, now.Add(time.Hour))
+	if long == nil {
+		t.Fatalf("failed to acquire")
+	}
+	r := rb.Reserve(1, []int{3})
+	if !r.Shadow.Equal(now.Add(time.Hour)) {
+		t.Fatalf("unexpected shadow time: %s", r.Shadow)
+	}
+	if rb.TryAcquireReserved(r, time.Time{}) != nil {
+		t.Fatalf("reserved usage exceeding capacity is acquired")
+	}
+
+	// One slot is left even after the reserved usage is acquired.
+	extra := rb.TryAcquire(1, []int{1}, now.Add(2*time.Hour))
+	if extra == nil {
+		t.Fatalf("failed to acquire an extra slot")
+	}
+	// A usage that can delay the reservation is rejected.
+	if rb.TryAcquire(1, []int{1}, now.Add(2*time.Hour)) != nil {
+		t.Fatalf("usage delaying the reservation is acquired")
+	}
+	if rb.TryAcquire(1, []int{1}, time.Time{}) != nil {
+		t.Fatalf("usage with unknown end is acquired")
+	}
+	// A usage that ends before the shadow time is backfilled.
+	short := rb.TryAcquire(1, []int{1}, now.Add(time.Minute))
+	if short == nil {
+		t.Fatalf("failed to backfill")
+	}
+
+	rb.Release(long)
+	if rb.TryAcquireReserved(r, time.Time{}) != nil {
+		t.Fatalf("reserved usage exceeding capacity is acquired")
+	}
+	rb.Release(short)
+	if rb.TryAcquireReserved(r, time.Time{}) == nil {
+		t.Fatalf("failed to acquire the reserved usage")
+	}
+	rb.Release(extra)
+	// The reservation is cancelled once it is acquired.
+	if rb.TryAcquire(1, []int{1}, time.Time{}) == nil {
+		t.Fatalf("failed to acquire after the reservation")
+	}
+}
+
+func TestResourceBucketsWithMultipleBuckets(t *testing.T) {
+	rb := resourcebuckets.NewResourceBuckets(3, 2)
+	a := rb.TryAcquire(1, []int{1}, time.Time{})
+	b := rb.TryAcquire(2, []int{2}, time.Time{})
+	if a == nil || b == nil {
+		t.Fatalf("failed to acquire")
+	}
+	if s := fmt.Sprint(a.Indexes, b.Indexes); s != "[0] [1 2]" {
+		t.Fatalf("unexpected indexes: %s", s)
+	}
+	// Only one bucket has a free slot.
+	if rb.TryAcquire(2, []int{1}, time.Time{}) != nil {
+		t.Fatalf("usage exceeding capacity is acquired")
+	}
+	rb.Release(b)
+	c := rb.TryAcquire(3, []int{1}, time.Time{})
+	if c == nil {
+		t.Fatalf("failed to acquire")
+	}
+	if s := fmt.Sprint(c.Indexes); s != "[1 2 0]" {
+		t.Fatalf("unexpected indexes: %s", s)
+	}
+	if rb.Reserve(4, []int{1}) != nil {
+		t.Fatalf("usage exceeding # of buckets is reserved")
+	}
+}
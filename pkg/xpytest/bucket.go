@@ -0,0 +1,111 @@
+package xpytest
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Bucket represents a device that tests can use.
+type Bucket struct {
+	// Device ID, which is given through CUDA_VISIBLE_DEVICES (e.g., "2").
+	Device string
+
+	// Capacity is # of threads that tests can use on the device.  A default
+	// number based on # of CPUs is used if this is 0.
+	Capacity int
+
+	// MemoryMB is memory in MB that tests can use on the device.  Memory is
+	// unlimited if this is 0.
+	MemoryMB int
+
+	// DeviceUnits is # of device units that tests can use on the device.
+	// Device units are unlimited if this is 0.
+	DeviceUnits int
+}
+
+// Dimensions of resources in a bucket.
+const (
+	cpuResource = iota
+	memoryResource
+	deviceResource
+	numResources
+)
+
+// ParseBuckets parses a comma-separated list of buckets, each of which is in
+// the form of "device[:capacity[:memory_mb[:device_units]]]" (e.g.,
+// "2:8,3:4:16000:2").
+func ParseBuckets(spec string) ([]Bucket, error) {
+	buckets := []Bucket{}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		fields := strings.Split(s, ":")
+		if len(fields) > 4 {
+			return nil, fmt.Errorf("too many fields in bucket: %s", s)
+		}
+		if fields[0] == "" {
+			return nil, fmt.Errorf("device of bucket must be given: %s", s)
+		}
+		b := Bucket{Device: fields[0]}
+		for i, p := range []*int{&b.Capacity, &b.MemoryMB, &b.DeviceUnits} {
+			if i+1 >= len(fields) {
+				break
+			}
+			v, err := strconv.Atoi(fields[i+1])
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("invalid capacity of bucket: %s", s)
+			}
+			*p = v
+		}
+		buckets = append(buckets, b)
+	}
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("no buckets are given: %s", spec)
+	}
+	return buckets, nil
+}
+
+// getBuckets returns buckets to use.  Xpytest.Buckets is preferred, otherwise
+// bucket buckets of thread capacities are used for devices 0..bucket-1.
+func (x *Xpytest) getBuckets(bucket, thread int) []Bucket {
+	buckets := append([]Bucket{}, x.Buckets...)
+	if len(buckets) == 0 {
+		for i := 0; i < bucket; i++ {
+			buckets = append(buckets,
+				Bucket{Device: fmt.Sprintf("%d", i), Capacity: thread})
+		}
+	}
+	for i := range buckets {
+		if buckets[i].Capacity == 0 {
+			buckets[i].Capacity =
+				(runtime.NumCPU() + len(buckets) - 1) / len(buckets)
+		}
+		if buckets[i].MemoryMB == 0 {
+			buckets[i].MemoryMB = x.BucketMemoryMB
+		}
+		if buckets[i].DeviceUnits == 0 {
+			buckets[i].DeviceUnits = x.BucketDeviceUnits
+		}
+	}
+	return buckets
+}
+
+// capacity returns a capacity vector of the bucket.  Unlimited resources have
+// a large enough capacity.
+func (b Bucket) capacity() []int {
+	c := make([]int, numResources)
+	c[cpuResource] = b.Capacity * resourceResolution
+	c[memoryResource] = b.MemoryMB
+	c[deviceResource] = b.DeviceUnits
+	for i := range c {
+		if c[i] == 0 {
+			c[i] = math.MaxInt32
+		}
+	}
+	return c
+}
@@ -0,0 +1,138 @@
+package xpytest
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+var testFilePattern = regexp.MustCompile(`^test_.*\.py$`)
+
+// documentExtensions are extensions of files that affect no tests unless a
+// mapping matches them.
+var documentExtensions = map[string]bool{".md": true, ".rst": true}
+
+// ChangedFiles returns files changed since the merge base of the given git
+// reference and HEAD, including uncommitted changes and untracked files that
+// are not ignored.  File paths are relative to dir, and files outside dir are
+// excluded.
+func ChangedFiles(dir, ref string) ([]string, error) {
+	base, err := git(dir, "merge-base", ref, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	out, err := git(dir, "diff", "--name-only", "--relative",
+		strings.TrimSpace(base))
+	if err != nil {
+		return nil, err
+	}
+	// git ls-files lists only files in dir with paths relative to dir.
+	untracked, err := git(dir, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, f := range strings.Split(out+untracked, "\n") {
+		if f != "" {
+			files = append(files, filepath.FromSlash(f))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// git runs a git command in dir and returns its standard output.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run git %s: %s: %s",
+			args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// SelectAffectedTests keeps only tests affected by the changed files.  A
+// changed test file affects itself, and a changed source file affects tests
+// that Mappings map it to.  A changed Python file that no mapping matches
+// affects tests importing it if ImportGraph is set.  Otherwise, the file
+// (e.g., foo.py) affects test files named after it (e.g., test_foo.py), and
+// all tests are kept if there are no such test files.  A mapping without
+// tests or a document (e.g., README.md) affects no tests, and all tests are
+// kept if any other changed file matches no mapping.
+func (x *Xpytest) SelectAffectedTests(changed []string) {
+	files := map[string]bool{}
+	names := map[string]bool{}
+	patterns := []string{}
+	sources := []string{}
+	for _, f := range changed {
+		f = filepath.Clean(f)
+		if testFilePattern.MatchString(filepath.Base(f)) {
+			files[f] = true
+			if x.ImportGraph != nil {
+				// Test files can be imported by other test files.
+				sources = append(sources, f)
+			}
+			continue
+		}
+		mapped := false
+		for _, m := range x.Mappings {
+			if matchesPattern(m.GetSource(), f) {
+				patterns = append(patterns, m.GetTests()...)
+				mapped = true
+			}
+		}
+		if mapped || documentExtensions[filepath.Ext(f)] {
+			continue
+		}
+		if filepath.Ext(f) != ".py" {
+			return
+		}
+		if x.ImportGraph != nil {
+			sources = append(sources, f)
+		} else {
+			names["test_"+filepath.Base(f)] = true
+		}
+	}
+	if len(sources) > 0 {
+		for _, f := range x.ImportGraph.Affected(sources) {
+			files[filepath.Clean(f)] = true
+		}
+	}
+	selected := []*xpytest_proto.TestQuery{}
+	matched := map[string]bool{}
+	for _, t := range x.GetTests() {
+		base := filepath.Base(t.File)
+		if names[base] {
+			matched[base] = true
+		}
+		if files[filepath.Clean(t.File)] || names[base] ||
+			matchesAny(patterns, t.File) {
+			selected = append(selected, t)
+		}
+	}
+	for name := range names {
+		if !matched[name] {
+			return
+		}
+	}
+	x.Tests = selected
+}
+
+func matchesAny(patterns []string, file string) bool {
+	for _, p := range patterns {
+		if matchesPattern(p, file) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package xpytest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/chainer/xpytest/pkg/pytest"
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// chunkGroup gathers results of chunks split from a test.
+type chunkGroup struct {
+	name    string
+	size    int
+	results []*pytest.Result
+	mutex   sync.Mutex
+}
+
+// add adds a result of a chunk.  This returns a merged result if results of
+// all the chunks are gathered, otherwise nil.
+func (g *chunkGroup) add(r *pytest.Result) *pytest.Result {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.results = append(g.results, r)
+	if len(g.results) < g.size {
+		return nil
+	}
+	return pytest.MergeResults(g.name, g.results)
+}
+
+// numChunks returns # of chunks that the given test should be split into.
+// ChunkThreshold applies only to tests whose # of chunks is not given.
+func (x *Xpytest) numChunks(tq *xpytest_proto.TestQuery) int {
+	chunks := int(tq.Chunks)
+	if chunks == 0 && x.ChunkThreshold > 0 {
+		threshold := float64(x.ChunkThreshold) / float64(time.Second)
+		chunks = int(math.Ceil(float64(tq.ExpectedDuration) / threshold))
+	}
+	return chunks
+}
+
+// splitTests splits tests into chunks of test cases.  This returns a map from
+// a chunk to its chunk group in addition to split tests.  A test is kept as is
+// if it fails to collect test cases.
+func (x *Xpytest) splitTests(
+	ctx context.Context, tests []*xpytest_proto.TestQuery,
+) ([]*xpytest_proto.TestQuery, map[*xpytest_proto.TestQuery]*chunkGroup) {
+	result := []*xpytest_proto.TestQuery{}
+	groups := map[*xpytest_proto.TestQuery]*chunkGroup{}
+	for _, t := range tests {
+		chunks := x.numChunks(t)
+		if chunks <= 1 || len(t.NodeIds) > 0 {
+			result = append(result, t)
+			continue
+		}
+		pt := pytest.NewPytestWithQuery(x.PytestBase, t)
+		nodeIDs, err := pt.Collect(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"[ERROR] failed to collect test cases: %s: %s\n", t.File, err)
+			result = append(result, t)
+			continue
+		}
+		if chunks > len(nodeIDs) {
+			chunks = len(nodeIDs)
+		}
+		if chunks <= 1 {
+			result = append(result, t)
+			continue
+		}
+		g := &chunkGroup{name: t.File, size: chunks}
+		for i := 0; i < chunks; i++ {
+			c := proto.Clone(t).(*xpytest_proto.TestQuery)
+			c.Chunks = int32(chunks)
+			c.NodeIds = nodeIDs[len(nodeIDs)*i/chunks : len(nodeIDs)*(i+1)/chunks]
+			c.ExpectedDuration = t.ExpectedDuration / float32(chunks)
+			result = append(result, c)
+			groups[c] = g
+		}
+	}
+	return result, groups
+}
@@ -0,0 +1,114 @@
+package xpytest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// historyDecay is a weight of a previous duration when a new duration is
+// recorded.  Durations are smoothed so that one slow run does not change the
+// schedule drastically.
+const historyDecay = 0.5
+
+// LoadHistoryFile loads durations of previous runs from a history file.  This
+// returns an empty history if the file does not exist yet.
+func LoadHistoryFile(file string) (*xpytest_proto.HistoryFile, error) {
+	buf, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &xpytest_proto.HistoryFile{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read history file: %s", err)
+	}
+	h := &xpytest_proto.HistoryFile{}
+	if err := proto.UnmarshalText(string(buf), h); err != nil {
+		return nil, fmt.Errorf("failed to parse history file: %s", err)
+	}
+	return h, nil
+}
+
+// SaveHistoryFile saves durations to a history file.
+func SaveHistoryFile(file string, h *xpytest_proto.HistoryFile) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".xpytest-history-")
+	if err != nil {
+		return fmt.Errorf("failed to create history file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := proto.MarshalText(tmp, h); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write history file: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write history file: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to save history file: %s", err)
+	}
+	return nil
+}
+
+// ApplyHistory sets expected durations of test cases based on durations of
+// previous runs.
+func (x *Xpytest) ApplyHistory(h *xpytest_proto.HistoryFile) error {
+	durations := map[string]float32{}
+	for _, e := range h.GetEntries() {
+		durations[e.GetName()] = e.GetTime()
+	}
+	for _, tq := range x.GetTests() {
+		if d, ok := durations[tq.GetFile()]; ok {
+			tq.ExpectedDuration = d
+		}
+	}
+	return nil
+}
+
+// UpdateHistory records durations of executed tests to the history.  Tests
+// that did not finish normally (e.g., HUNG) update only their memory usage
+// because their durations are not reliable.
+func (x *Xpytest) UpdateHistory(h *xpytest_proto.HistoryFile) error {
+	entries := map[string]*xpytest_proto.HistoryFile_Entry{}
+	for _, e := range h.GetEntries() {
+		entries[e.GetName()] = e
+	}
+	for _, r := range x.TestResults {
+		reliable := false
+		switch r.GetStatus() {
+		case xpytest_proto.TestResult_SUCCESS,
+			xpytest_proto.TestResult_FLAKY,
+			xpytest_proto.TestResult_FAILED,
+			xpytest_proto.TestResult_TIMEOUT:
+			reliable = true
+		}
+		if !reliable && r.GetMaxRss() <= 0 {
+			continue
+		}
+		e, ok := entries[r.GetName()]
+		if !ok {
+			e = &xpytest_proto.HistoryFile_Entry{Name: r.GetName()}
+			entries[r.GetName()] = e
+		}
+		if reliable && e.GetTime() > 0 {
+			e.Time = e.GetTime()*historyDecay +
+				r.GetTime()*(1-historyDecay)
+		} else if reliable {
+			e.Time = r.GetTime()
+		}
+		if r.GetMaxRss() > 0 {
+			e.MaxRss = r.GetMaxRss()
+		}
+	}
+	h.Entries = make([]*xpytest_proto.HistoryFile_Entry, 0, len(entries))
+	for _, e := range entries {
+		h.Entries = append(h.Entries, e)
+	}
+	sort.Slice(h.Entries, func(i, j int) bool {
+		return h.Entries[i].GetName() < h.Entries[j].GetName()
+	})
+	return nil
+}
@@ -0,0 +1,98 @@
+package xpytest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar"
+
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+const expiryLayout = "2006-01-02"
+
+// applyQuarantines quarantines tests that the given quarantines match.  This
+// prints a warning for each quarantine whose expiry date has passed.
+func (x *Xpytest) applyQuarantines(
+	quarantines []*xpytest_proto.HintFile_Quarantine, now time.Time,
+) error {
+	for _, q := range quarantines {
+		expired, err := isExpired(q, now)
+		if err != nil {
+			return err
+		}
+		if expired {
+			fmt.Fprintf(os.Stderr,
+				"[WARNING] quarantine of %s has expired: %s\n",
+				q.GetName(), quarantineSummary(q))
+		}
+	}
+	for _, tq := range x.GetTests() {
+		for _, q := range quarantines {
+			if matchesPattern(q.GetName(), tq.GetFile()) {
+				tq.Quarantine = q
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// isExpired returns true if the expiry date of the quarantine has passed.
+func isExpired(
+	q *xpytest_proto.HintFile_Quarantine, now time.Time,
+) (bool, error) {
+	if q.GetExpiry() == "" {
+		return false, nil
+	}
+	expiry, err := time.ParseInLocation(
+		expiryLayout, q.GetExpiry(), now.Location())
+	if err != nil {
+		return false, fmt.Errorf(
+			"invalid expiry of quarantine: %s: %s", q.GetName(), err)
+	}
+	return !now.Before(expiry.AddDate(0, 0, 1)), nil
+}
+
+// matchesPattern returns true if the name of a quarantine or a mapping matches
+// the file.  The name can be a glob pattern, and its parent directories can be
+// omitted.
+func matchesPattern(name, file string) bool {
+	if file == name || strings.HasSuffix(
+		file, string(filepath.Separator)+name) {
+		return true
+	}
+	if ok, err := doublestar.Match(name, file); err == nil && ok {
+		return true
+	}
+	ok, err := doublestar.Match("**/"+name, file)
+	return err == nil && ok
+}
+
+// quarantineSummary returns a one-line description of a quarantine (e.g.,
+// "flaky on CI (owner: alice, expires on 2020-01-01)").
+func quarantineSummary(q *xpytest_proto.HintFile_Quarantine) string {
+	ss := []string{}
+	if q.GetOwner() != "" {
+		ss = append(ss, "owner: "+q.GetOwner())
+	}
+	if q.GetExpiry() != "" {
+		expired, _ := isExpired(q, time.Now())
+		if expired {
+			ss = append(ss, "expired on "+q.GetExpiry())
+		} else {
+			ss = append(ss, "expires on "+q.GetExpiry())
+		}
+	}
+	s := q.GetReason()
+	if s == "" {
+		s = "no reason"
+	}
+	if len(ss) > 0 {
+		s += " (" + strings.Join(ss, ", ") + ")"
+	}
+	return s
+}
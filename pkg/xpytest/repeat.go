@@ -0,0 +1,105 @@
+package xpytest
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/chainer/xpytest/pkg/pytest"
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// repeatTests returns n copies of tests for flakiness hunting.  Copies of
+// chunks belong to copies of their chunk groups.  Each copy runs only once
+// because retries hide flakiness, and copies are ordered round by round so
+// that every test makes progress.
+func repeatTests(
+	tests []*xpytest_proto.TestQuery,
+	chunkGroups map[*xpytest_proto.TestQuery]*chunkGroup, n int,
+) ([]*xpytest_proto.TestQuery, map[*xpytest_proto.TestQuery]*chunkGroup) {
+	result := []*xpytest_proto.TestQuery{}
+	groups := map[*xpytest_proto.TestQuery]*chunkGroup{}
+	for i := 0; i < n; i++ {
+		copies := map[*chunkGroup]*chunkGroup{}
+		for _, t := range tests {
+			c := proto.Clone(t).(*xpytest_proto.TestQuery)
+			c.Retry = 1
+			if g, ok := chunkGroups[t]; ok {
+				if _, ok := copies[g]; !ok {
+					copies[g] = &chunkGroup{name: g.name, size: g.size}
+				}
+				groups[c] = copies[g]
+			}
+			result = append(result, c)
+		}
+	}
+	return result, groups
+}
+
+// printRepeatSummary prints pass rates of repeated tests and the first failure
+// of each test.  Runs that did not start (e.g., because of UntilFailure) are
+// counted separately from the pass rates.
+func printRepeatSummary(results []*pytest.Result) {
+	type stat struct {
+		name                  string
+		runs, passed, skipped int
+		firstFailure          *pytest.Result
+	}
+	stats := []*stat{}
+	statByName := map[string]*stat{}
+	for _, r := range results {
+		if r.Status == xpytest_proto.TestResult_INTERRUPTED {
+			continue
+		}
+		s, ok := statByName[r.Name]
+		if !ok {
+			s = &stat{name: r.Name}
+			statByName[r.Name] = s
+			stats = append(stats, s)
+		}
+		if r.Status == xpytest_proto.TestResult_NOT_RUN {
+			s.skipped++
+			continue
+		}
+		s.runs++
+		if !isFailure(r.Status) {
+			s.passed++
+		} else if s.firstFailure == nil {
+			s.firstFailure = r
+		}
+	}
+	failureRate := func(s *stat) float64 {
+		if s.runs == 0 {
+			return 0
+		}
+		return float64(s.runs-s.passed) / float64(s.runs)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if failureRate(a) != failureRate(b) {
+			return failureRate(a) > failureRate(b)
+		}
+		return a.name < b.name
+	})
+
+	fmt.Printf("\n%s\n", horizon("REPEAT SUMMARY"))
+	fmt.Printf("%10s %10s %13s %8s %s\n", "FAILED", "PASSED",
+		"PASSED/RUNS", "NOT RUN", "TEST")
+	for _, s := range stats {
+		passRate := 0.0
+		if s.runs > 0 {
+			passRate = float64(s.passed) / float64(s.runs)
+		}
+		fmt.Printf("%9.1f%% %9.1f%% %13s %8d %s\n",
+			failureRate(s)*100, passRate*100,
+			fmt.Sprintf("%d/%d", s.passed, s.runs), s.skipped, s.name)
+	}
+	for _, s := range stats {
+		if s.firstFailure == nil {
+			continue
+		}
+		fmt.Printf("\n%s\n", horizon("FIRST FAILURE: "+s.name))
+		fmt.Println(s.firstFailure.Output())
+	}
+}
@@ -0,0 +1,140 @@
+package xpytest
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chainer/xpytest/pkg/pytest"
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// resourceRequest returns a resource vector that a test requires from each of
+// k buckets.  A test requiring more than buckets have uses whole buckets so
+// that it can run eventually.
+func resourceRequest(
+	t *xpytest_proto.TestQuery, capacities [][]int, k int,
+) []int {
+	cpu := float64(resourceResolution)
+	if t.Xdist > 0 {
+		cpu *= float64(t.Xdist)
+	}
+	if t.Resource > 0 {
+		cpu *= float64(t.Resource)
+	}
+	req := make([]int, numResources)
+	req[cpuResource] = int(math.Ceil(cpu))
+	req[memoryResource] = int(t.MemoryMb)
+	req[deviceResource] = int(t.DeviceUnits)
+
+	fitting := 0
+	for _, c := range capacities {
+		if fitsIn(req, c) {
+			fitting++
+		}
+	}
+	if fitting >= k {
+		return req
+	}
+	// Limit the request to what the k buckets having the most CPUs can
+	// provide.
+	sorted := append([][]int{}, capacities...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i][cpuResource] > sorted[j][cpuResource]
+	})
+	for _, c := range sorted[:k] {
+		for i := range req {
+			if req[i] > c[i] {
+				req[i] = c[i]
+			}
+		}
+	}
+	return req
+}
+
+// numBuckets returns # of buckets that a test uses at once.  This can exceed
+// # of buckets, and such a test cannot run (see Execute).
+func numBuckets(t *xpytest_proto.TestQuery) int {
+	k := int(t.Buckets)
+	if k < 1 {
+		k = 1
+	}
+	return k
+}
+
+func fitsIn(req, capacity []int) bool {
+	for i := range req {
+		if req[i] > capacity[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// estimateEnd returns the time when a test starting at now is expected to
+// end.  An expected duration is preferred, and otherwise the deadline of all
+// trials bounds it.
+func (x *Xpytest) estimateEnd(
+	t *xpytest_proto.TestQuery, now time.Time,
+) time.Time {
+	if t.ExpectedDuration > 0 {
+		return now.Add(time.Duration(t.ExpectedDuration*1e6) * time.Microsecond)
+	}
+	pt := pytest.NewPytestWithQuery(x.PytestBase, t)
+	if pt.Deadline <= 0 {
+		return time.Time{}
+	}
+	trials := pt.Retry
+	if trials < 1 {
+		trials = 1
+	}
+	return now.Add(pt.Deadline * time.Duration(trials))
+}
+
+func indexOf(tests []*xpytest_proto.TestQuery, t *xpytest_proto.TestQuery) int {
+	for i, u := range tests {
+		if u == t {
+			return i
+		}
+	}
+	return -1
+}
+
+// lockSet is a set of locks held by running tests.
+type lockSet struct {
+	held  map[string]bool
+	mutex sync.Mutex
+}
+
+func newLockSet() *lockSet {
+	return &lockSet{held: map[string]bool{}}
+}
+
+// available returns true if none of the given locks is held or wanted.
+func (s *lockSet) available(names []string, wanted map[string]bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, n := range names {
+		if s.held[n] || wanted[n] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *lockSet) lock(names []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, n := range names {
+		s.held[n] = true
+	}
+}
+
+func (s *lockSet) unlock(names []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, n := range names {
+		delete(s.held, n)
+	}
+}
@@ -0,0 +1,82 @@
+package xpytest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// SelectShard keeps only tests that belong to the index-th shard out of count
+// shards.  Tests are distributed so that shards have similar costs, and the
+// distribution depends only on tests and hints.  Therefore, machines that
+// have the same tests and apply the same hint can run their own shards without
+// overlaps.  NOTE: Histories are not used because each machine records only the
+// tests that it runs, so histories of machines differ.
+func (x *Xpytest) SelectShard(index, count int) error {
+	if count <= 0 {
+		return fmt.Errorf("# of shards must be positive: %d", count)
+	}
+	if index < 0 || count <= index {
+		return fmt.Errorf(
+			"shard index must be in [0, %d): %d", count, index)
+	}
+
+	tests := append([]*xpytest_proto.TestQuery{}, x.GetTests()...)
+	weights := map[*xpytest_proto.TestQuery]float64{}
+	for _, t := range tests {
+		weights[t] = x.shardWeight(t)
+	}
+	sort.SliceStable(tests, func(i, j int) bool {
+		a, b := tests[i], tests[j]
+		if weights[a] == weights[b] {
+			return a.File < b.File
+		}
+		return weights[a] > weights[b]
+	})
+
+	// Assign tests to the least loaded shard in descending order of costs.
+	loads := make([]float64, count)
+	selected := []*xpytest_proto.TestQuery{}
+	for _, t := range tests {
+		shard := 0
+		for i := range loads {
+			if loads[i] < loads[shard] {
+				shard = i
+			}
+		}
+		loads[shard] += weights[t]
+		if shard == index {
+			selected = append(selected, t)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].File < selected[j].File
+	})
+	x.Tests = selected
+	return nil
+}
+
+// shardWeight estimates a cost of a test, which is a product of its deadline
+// and its resource usage.
+func (x *Xpytest) shardWeight(t *xpytest_proto.TestQuery) float64 {
+	duration := float64(t.Deadline)
+	if duration <= 0 && x.PytestBase != nil {
+		duration = float64(x.PytestBase.Deadline) / float64(time.Second)
+	}
+	if duration <= 0 {
+		duration = 1.0
+	}
+	resource := 1.0
+	if t.Xdist > 0 {
+		resource *= float64(t.Xdist)
+	}
+	if t.Resource > 0 {
+		resource *= float64(t.Resource)
+	}
+	if t.Buckets > 0 {
+		resource *= float64(t.Buckets)
+	}
+	return duration * resource
+}
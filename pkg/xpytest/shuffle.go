@@ -0,0 +1,33 @@
+package xpytest
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// resolveSeed chooses a random seed unless Xpytest.Seed is given, and it
+// prints the seed so that the order can be reproduced.
+func (x *Xpytest) resolveSeed() {
+	if x.Seed == 0 {
+		x.Seed = time.Now().UnixNano()
+	}
+	fmt.Printf("Using --seed=%d\n", x.Seed)
+}
+
+// seedArg returns an argument that gives Xpytest.Seed to pytest-randomly.
+func (x *Xpytest) seedArg() string {
+	return fmt.Sprintf("--randomly-seed=%d", x.Seed)
+}
+
+// shuffleTests shuffles tests with Xpytest.Seed.  The same seed gives the same
+// queue for the same tests, and tests start in the order of the queue (see
+// Xpytest.Shuffle).
+func (x *Xpytest) shuffleTests(tests []*xpytest_proto.TestQuery) {
+	r := rand.New(rand.NewSource(x.Seed))
+	r.Shuffle(len(tests), func(i, j int) {
+		tests[i], tests[j] = tests[j], tests[i]
+	})
+}
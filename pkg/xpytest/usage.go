@@ -0,0 +1,61 @@
+package xpytest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/chainer/xpytest/pkg/pytest"
+	"github.com/chainer/xpytest/pkg/reporter"
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// maxResourceUsageRows is # of tests that printResourceUsage shows.
+const maxResourceUsageRows = 10
+
+// printResourceUsage prints resource usage of the tests using the most memory.
+// The lines are also logged to the reporter if any.
+func printResourceUsage(
+	ctx context.Context, results []*pytest.Result,
+	reporter reporter.Reporter,
+) {
+	usages := []*xpytest_proto.TestResult{}
+	for _, r := range results {
+		tr := r.TestResult()
+		if tr.GetMaxRss() > 0 || tr.GetUserTime() > 0 ||
+			tr.GetSystemTime() > 0 {
+			usages = append(usages, tr)
+		}
+	}
+	if len(usages) == 0 {
+		return
+	}
+	sort.SliceStable(usages, func(i, j int) bool {
+		a, b := usages[i], usages[j]
+		if a.GetMaxRss() != b.GetMaxRss() {
+			return a.GetMaxRss() > b.GetMaxRss()
+		}
+		return a.GetName() < b.GetName()
+	})
+	if len(usages) > maxResourceUsageRows {
+		usages = usages[:maxResourceUsageRows]
+	}
+
+	lines := []string{fmt.Sprintf("%10s %9s %9s %9s %15s %s",
+		"MAX RSS", "USER", "SYS", "WALL", "CTX SWITCHES", "TEST")}
+	for _, u := range usages {
+		lines = append(lines, fmt.Sprintf("%10s %8.1fs %8.1fs %8.1fs %15s %s",
+			pytest.FormatBytes(u.GetMaxRss()), u.GetUserTime(),
+			u.GetSystemTime(), u.GetTime(),
+			fmt.Sprintf("%d/%d", u.GetVoluntaryContextSwitches(),
+				u.GetInvoluntaryContextSwitches()),
+			u.GetName()))
+	}
+	fmt.Printf("\n%s\n", horizon("RESOURCE USAGE"))
+	for _, l := range lines {
+		fmt.Println(l)
+		if reporter != nil {
+			reporter.Log(ctx, l)
+		}
+	}
+}
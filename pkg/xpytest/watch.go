@@ -0,0 +1,195 @@
+package xpytest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	xpytest_proto "github.com/chainer/xpytest/proto"
+)
+
+// Watcher watches Python files in directories.  Watcher polls modification
+// times of files so that it works without platform-specific notifications.
+type Watcher struct {
+	Dirs []string
+
+	// Interval is an interval of polling.
+	Interval time.Duration
+
+	mtimes map[string]time.Time
+}
+
+// NewWatcher creates a new Watcher watching the given directories.
+func NewWatcher(dirs []string) *Watcher {
+	return &Watcher{Dirs: dirs, Interval: time.Second}
+}
+
+// WatchDirs returns directories to watch for the given file patterns, which
+// are the longest parent directories without wildcards.
+func WatchDirs(patterns []string) []string {
+	dirs := []string{}
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		parts := strings.Split(filepath.ToSlash(p), "/")
+		static := []string{}
+		for _, part := range parts[:len(parts)-1] {
+			if strings.ContainsAny(part, "*?[{") {
+				break
+			}
+			static = append(static, part)
+		}
+		dir := filepath.FromSlash(strings.Join(static, "/"))
+		if dir == "" {
+			dir = "."
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Snapshot records the current modification times of files, which Wait
+// compares with.
+func (w *Watcher) Snapshot() error {
+	mtimes, err := w.scan()
+	if err != nil {
+		return err
+	}
+	w.mtimes = mtimes
+	return nil
+}
+
+// Wait blocks until files are added, modified or removed, and it returns the
+// changed files.  Changes are gathered until files stop changing for an
+// interval so that a save of multiple files is handled at once.
+func (w *Watcher) Wait(ctx context.Context) ([]string, error) {
+	if w.mtimes == nil {
+		if err := w.Snapshot(); err != nil {
+			return nil, err
+		}
+	}
+	changed := map[string]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(w.Interval):
+		}
+		mtimes, err := w.scan()
+		if err != nil {
+			return nil, err
+		}
+		n := len(changed)
+		for f, t := range mtimes {
+			if prev, ok := w.mtimes[f]; !ok || !prev.Equal(t) {
+				changed[f] = true
+			}
+		}
+		for f := range w.mtimes {
+			if _, ok := mtimes[f]; !ok {
+				changed[f] = true
+			}
+		}
+		w.mtimes = mtimes
+		if len(changed) > 0 && len(changed) == n {
+			break
+		}
+	}
+	files := []string{}
+	for f := range changed {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// scan returns modification times of Python files in the directories.  Hidden
+// directories and caches are skipped.
+func (w *Watcher) scan() (map[string]time.Time, error) {
+	mtimes := map[string]time.Time{}
+	for _, dir := range w.Dirs {
+		err := filepath.Walk(dir, func(
+			path string, info os.FileInfo, err error,
+		) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			name := info.Name()
+			if info.IsDir() {
+				if path != dir && (strings.HasPrefix(name, ".") ||
+					name == "__pycache__") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(name) == ".py" {
+				mtimes[filepath.Clean(path)] = info.ModTime()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan directory: %s: %s", dir, err)
+		}
+	}
+	return mtimes, nil
+}
+
+// Statuses has the latest statuses of tests in watch mode.
+type Statuses map[string]xpytest_proto.TestResult_Status
+
+// Update records the statuses of the given results, and it prints changes
+// from the previous statuses.
+func (s Statuses) Update(results []*xpytest_proto.TestResult) {
+	first := len(s) == 0
+	lines := []string{}
+	fixed, broken, added, failing, passing := 0, 0, 0, 0, 0
+	for _, r := range results {
+		status := r.GetStatus()
+		if status == xpytest_proto.TestResult_NOT_RUN ||
+			status == xpytest_proto.TestResult_INTERRUPTED {
+			continue
+		}
+		prev, ok := s[r.GetName()]
+		s[r.GetName()] = status
+		switch {
+		case ok && isFailure(prev) && !isFailure(status):
+			fixed++
+			lines = append(lines, fmt.Sprintf("[FIXED] %s (%s -> %s)",
+				r.GetName(), prev, status))
+		case !ok && isFailure(status):
+			broken++
+			lines = append(lines, fmt.Sprintf("[BROKEN] %s (new -> %s)",
+				r.GetName(), status))
+		case !ok:
+			added++
+			lines = append(lines, fmt.Sprintf("[NEW] %s (%s)",
+				r.GetName(), status))
+		case !isFailure(prev) && isFailure(status):
+			broken++
+			lines = append(lines, fmt.Sprintf("[BROKEN] %s (%s -> %s)",
+				r.GetName(), prev, status))
+		case isFailure(status):
+			failing++
+		default:
+			passing++
+		}
+	}
+	if first {
+		return
+	}
+	fmt.Printf("\n%s\n", horizon("CHANGES FROM LAST RUN"))
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+	fmt.Printf("%d fixed, %d broken, %d new, %d still failing, "+
+		"%d still passing\n", fixed, broken, added, failing, passing)
+}
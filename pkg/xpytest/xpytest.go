@@ -3,17 +3,19 @@ package xpytest
 import (
 	"context"
 	"fmt"
-	"math"
 	"path/filepath"
 	"regexp"
-	"runtime"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bmatcuk/doublestar"
+	"github.com/golang/protobuf/proto"
 
+	"github.com/chainer/xpytest/pkg/farm"
+	"github.com/chainer/xpytest/pkg/importgraph"
 	"github.com/chainer/xpytest/pkg/pytest"
 	"github.com/chainer/xpytest/pkg/reporter"
 	"github.com/chainer/xpytest/pkg/resourcebuckets"
@@ -28,6 +30,64 @@ type Xpytest struct {
 	Tests       []*xpytest_proto.TestQuery
 	TestResults []*xpytest_proto.TestResult
 	Status      xpytest_proto.TestResult_Status
+
+	// ChunkThreshold is a threshold of expected durations.  A test expected
+	// to take longer is split into chunks, each of which is expected to take
+	// about the threshold.  Tests are not split by durations if this is 0.
+	ChunkThreshold time.Duration
+
+	// MaxFailures is # of failed tests to abort execution.  Once this number
+	// of tests fail, running tests are cancelled and the remaining tests do
+	// not start.  Execution is not aborted if this is 0.
+	MaxFailures int
+
+	// TotalDeadline is a time budget of execution.  No tests start after
+	// TotalDeadline-GracePeriod passes, and running tests are cancelled when
+	// TotalDeadline passes.  GracePeriod must be shorter than TotalDeadline.
+	// There is no time budget if this is 0.
+	TotalDeadline time.Duration
+	GracePeriod   time.Duration
+
+	// Buckets are devices and their capacities to run tests.  If this is
+	// set, Execute ignores its bucket and thread arguments.
+	Buckets []Bucket
+
+	// BucketMemoryMB and BucketDeviceUnits are default capacities of buckets
+	// that do not specify them.  They are unlimited if these are 0.
+	BucketMemoryMB    int
+	BucketDeviceUnits int
+
+	// DeferRetries defers retries of failed tests until all the tests run
+	// once, so that flaky tests failing because of contention can be retried
+	// under less contention.  At most RerunConcurrency tests rerun at once
+	// unless it is 0, and they rerun without pytest-xdist if
+	// RerunWithoutXdist is true.
+	DeferRetries      bool
+	RerunConcurrency  int
+	RerunWithoutXdist bool
+
+	// Repeat is # of times that each test runs to hunt flaky tests.  Each run
+	// has no retries, and pass rates are reported.  If UntilFailure is true,
+	// a test does not run any more once it fails.
+	Repeat       int
+	UntilFailure bool
+
+	// Shuffle runs tests in a random order to find tests depending on other
+	// tests.  Seed is a seed of the order, which is chosen randomly if it is
+	// 0.  Shuffled tests start strictly in the order of the queue without
+	// backfilling, so the same seed and the same buckets reproduce the same
+	// order.  If PassSeed is true, the seed is also given to pytest through
+	// --randomly-seed (for pytest-randomly).
+	Shuffle  bool
+	Seed     int64
+	PassSeed bool
+
+	// Mappings map source files to tests for SelectAffectedTests.  If
+	// ImportGraph is set, SelectAffectedTests finds tests importing changed
+	// Python files with it instead of their names.  The root of ImportGraph
+	// should be the directory that paths of tests are relative to.
+	Mappings    []*xpytest_proto.HintFile_Mapping
+	ImportGraph *importgraph.Graph
 }
 
 // NewXpytest creates a new Xpytest.
@@ -85,125 +145,644 @@ func (x *Xpytest) ApplyHint(h *xpytest_proto.HintFile) error {
 				if rule.GetResource() > 0 {
 					tq.Resource = rule.GetResource()
 				}
+				if rule.GetChunks() > 0 {
+					tq.Chunks = rule.GetChunks()
+				}
+				if rule.GetMemoryMb() > 0 {
+					tq.MemoryMb = rule.GetMemoryMb()
+				}
+				if rule.GetDeviceUnits() > 0 {
+					tq.DeviceUnits = rule.GetDeviceUnits()
+				}
+				if rule.GetBuckets() > 0 {
+					tq.Buckets = rule.GetBuckets()
+				}
+				if len(rule.GetLocks()) > 0 {
+					tq.Locks = rule.GetLocks()
+				}
+				if rule.GetIdleTimeout() > 0 {
+					tq.IdleTimeout = rule.GetIdleTimeout()
+				}
+				if rule.GetMemoryLimitMb() > 0 {
+					tq.MemoryLimitMb = rule.GetMemoryLimitMb()
+				}
+				if rule.GetCpuTimeLimit() > 0 {
+					tq.CpuTimeLimit = rule.GetCpuTimeLimit()
+				}
 			}
 		}
 	}
-	return nil
+	x.Mappings = append(x.Mappings, h.GetMappings()...)
+	return x.applyQuarantines(h.GetQuarantines(), time.Now())
 }
 
-// Execute runs tests.
-func (x *Xpytest) Execute(
-	ctx context.Context, bucket int, thread int,
-	reporter reporter.Reporter,
-) error {
-	tests := append([]*xpytest_proto.TestQuery{}, x.Tests...)
+// prepareTests splits tests into chunks and sorts them in the order that they
+// should start.
+func (x *Xpytest) prepareTests(ctx context.Context) (
+	[]*xpytest_proto.TestQuery, map[*xpytest_proto.TestQuery]*chunkGroup,
+) {
+	tests, chunkGroups := x.splitTests(ctx, x.Tests)
 
+	// Tests run in the longest-processing-time-first order.  Tests whose
+	// durations are unknown are ordered by their deadlines given by hints
+	// instead, and tests with neither follow.  Hint priorities are used if
+	// durations do not decide the order.
 	sort.SliceStable(tests, func(i, j int) bool {
 		a, b := tests[i], tests[j]
+		if da, db := sortDuration(a), sortDuration(b); da != db {
+			return da > db
+		}
 		if a.Priority == b.Priority {
 			return a.File < b.File
 		}
 		return a.Priority > b.Priority
 	})
+	return tests, chunkGroups
+}
+
+// sortDuration returns a duration that orders the test in prepareTests.  This
+// is the expected duration if known, or the deadline otherwise.
+func sortDuration(t *xpytest_proto.TestQuery) float32 {
+	if t.ExpectedDuration > 0 {
+		return t.ExpectedDuration
+	}
+	return t.Deadline
+}
+
+// printResults prints results sent through resultChan, and it prints a
+// summary when resultChan is closed.
+func (x *Xpytest) printResults(
+	ctx context.Context, reporter reporter.Reporter,
+	resultChan <-chan *pytest.Result,
+) {
+	quarantines := map[string]*xpytest_proto.HintFile_Quarantine{}
+	for _, t := range x.GetTests() {
+		if t.Quarantine != nil {
+			quarantines[t.File] = t.Quarantine
+		}
+	}
+	allTests := []*pytest.Result{}
+	quarantinedTests := []*pytest.Result{}
+	passedTests := []*pytest.Result{}
+	flakyTests := []*pytest.Result{}
+	failedTests := []*pytest.Result{}
+	notRunTests := []*pytest.Result{}
+	interruptedTests := []*pytest.Result{}
+	for {
+		r, ok := <-resultChan
+		if !ok {
+			break
+		}
+		fmt.Println(r.Output())
+		x.TestResults = append(x.TestResults, r.TestResult())
+		allTests = append(allTests, r)
+		if _, ok := quarantines[r.Name]; ok {
+			quarantinedTests = append(quarantinedTests, r)
+		} else if r.Status == xpytest_proto.TestResult_SUCCESS {
+			passedTests = append(passedTests, r)
+		} else if r.Status == xpytest_proto.TestResult_FLAKY {
+			flakyTests = append(flakyTests, r)
+		} else if r.Status == xpytest_proto.TestResult_NOT_RUN {
+			notRunTests = append(notRunTests, r)
+		} else if r.Status == xpytest_proto.TestResult_INTERRUPTED {
+			interruptedTests = append(interruptedTests, r)
+		} else {
+			failedTests = append(failedTests, r)
+		}
+	}
+	x.Status = xpytest_proto.TestResult_SUCCESS
+	if len(flakyTests) > 0 {
+		fmt.Printf("\n%s\n", horizon("FLAKY TESTS"))
+		for _, t := range flakyTests {
+			fmt.Printf("%s\n", t.Summary())
+			if reporter != nil {
+				reporter.Log(ctx, t.Summary())
+			}
+		}
+		x.Status = xpytest_proto.TestResult_FLAKY
+	}
+	if len(notRunTests) > 0 {
+		fmt.Printf("\n%s\n", horizon("NOT RUN TESTS"))
+		for _, t := range notRunTests {
+			fmt.Printf("%s\n", t.Summary())
+		}
+		x.Status = xpytest_proto.TestResult_NOT_RUN
+	}
+	if len(interruptedTests) > 0 {
+		fmt.Printf("\n%s\n", horizon("INTERRUPTED TESTS"))
+		for _, t := range interruptedTests {
+			fmt.Printf("%s\n", t.Summary())
+		}
+	}
+	if len(quarantinedTests) > 0 {
+		fmt.Printf("\n%s\n", horizon("QUARANTINED TESTS"))
+		for _, t := range quarantinedTests {
+			fmt.Printf("%s\n    quarantined: %s\n", t.Summary(),
+				quarantineSummary(quarantines[t.Name]))
+		}
+	}
+	if len(failedTests) > 0 {
+		fmt.Printf("\n%s\n", horizon("FAILED TESTS"))
+		for _, t := range failedTests {
+			fmt.Printf("%s\n", t.Summary())
+			for _, l := range t.HangLocations() {
+				fmt.Printf("    hung at %s\n", l)
+			}
+		}
+		x.Status = xpytest_proto.TestResult_FAILED
+	}
+	if ctx.Err() != nil {
+		x.Status = xpytest_proto.TestResult_INTERRUPTED
+	}
+	if x.Repeat > 1 {
+		printRepeatSummary(allTests)
+	}
+	printResourceUsage(ctx, allTests, reporter)
+	fmt.Printf("\n%s\n", horizon("TEST SUMMARY"))
+	summary := fmt.Sprintf("%d failed, %d flaky, %d passed",
+		len(failedTests), len(flakyTests), len(passedTests))
+	if len(notRunTests) > 0 {
+		summary += fmt.Sprintf(", %d not run", len(notRunTests))
+	}
+	if len(interruptedTests) > 0 {
+		summary += fmt.Sprintf(", %d interrupted", len(interruptedTests))
+	}
+	if len(quarantinedTests) > 0 {
+		summary += fmt.Sprintf(", %d quarantined", len(quarantinedTests))
+	}
+	fmt.Println(summary)
+}
+
+// isFailure returns true if the status should count as a failure.
+func isFailure(status xpytest_proto.TestResult_Status) bool {
+	switch status {
+	case xpytest_proto.TestResult_SUCCESS,
+		xpytest_proto.TestResult_FLAKY,
+		xpytest_proto.TestResult_NOT_RUN,
+		xpytest_proto.TestResult_INTERRUPTED:
+		return false
+	}
+	return true
+}
+
+// Execute runs tests.
+func (x *Xpytest) Execute(
+	ctx context.Context, bucket int, thread int,
+	reporter reporter.Reporter,
+) error {
+	if x.TotalDeadline > 0 && x.GracePeriod >= x.TotalDeadline {
+		return fmt.Errorf(
+			"grace period must be shorter than total deadline: %s >= %s",
+			x.GracePeriod, x.TotalDeadline)
+	}
+	// TotalDeadline includes time to collect test cases of chunks.
+	startTime := time.Now()
+	prepareCtx := ctx
+	if x.TotalDeadline > 0 {
+		var cancel context.CancelFunc
+		prepareCtx, cancel = context.WithTimeout(
+			ctx, x.TotalDeadline-x.GracePeriod)
+		defer cancel()
+	}
+	tests, chunkGroups := x.prepareTests(prepareCtx)
+	if x.Repeat > 1 {
+		tests, chunkGroups = repeatTests(tests, chunkGroups, x.Repeat)
+	}
+	if x.Shuffle || x.PassSeed {
+		x.resolveSeed()
+	}
+	if x.Shuffle {
+		x.shuffleTests(tests)
+	}
 
-	if thread == 0 {
-		thread = (runtime.NumCPU() + bucket - 1) / bucket
+	buckets := x.getBuckets(bucket, thread)
+	capacities := [][]int{}
+	maxThreads := 0
+	for _, b := range buckets {
+		capacities = append(capacities, b.capacity())
+		if maxThreads < b.Capacity {
+			maxThreads = b.Capacity
+		}
 	}
-	rb := resourcebuckets.NewResourceBuckets(bucket, thread*resourceResolution)
-	resultChan := make(chan *pytest.Result, thread)
+	rb := resourcebuckets.NewMultiResourceBuckets(capacities)
+	resultChan := make(chan *pytest.Result, maxThreads)
 
 	printer := sync.WaitGroup{}
 	printer.Add(1)
 	go func() {
 		defer printer.Done()
-		passedTests := []*pytest.Result{}
-		flakyTests := []*pytest.Result{}
-		failedTests := []*pytest.Result{}
-		for {
-			r, ok := <-resultChan
-			if !ok {
-				break
+		x.printResults(ctx, reporter, resultChan)
+	}()
+
+	// send sends a result to the printer after merging results of chunks.
+	send := func(t *xpytest_proto.TestQuery, r *pytest.Result) {
+		if g, ok := chunkGroups[t]; ok {
+			if r = g.add(r); r == nil {
+				return
 			}
-			fmt.Println(r.Output())
-			if r.Status == xpytest_proto.TestResult_SUCCESS {
-				passedTests = append(passedTests, r)
-			} else if r.Status == xpytest_proto.TestResult_FLAKY {
-				flakyTests = append(flakyTests, r)
-			} else {
-				failedTests = append(failedTests, r)
+		}
+		resultChan <- r
+	}
+
+	// Running tests are cancelled through runCtx when execution is aborted,
+	// and no tests start once startCtx is cancelled.
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	startCtx, cancelStart := context.WithCancel(runCtx)
+	defer cancelStart()
+	reason := ""
+	reasonMutex := sync.Mutex{}
+	setReason := func(r string) {
+		reasonMutex.Lock()
+		defer reasonMutex.Unlock()
+		if reason == "" {
+			reason = r
+		}
+	}
+	getReason := func() string {
+		reasonMutex.Lock()
+		defer reasonMutex.Unlock()
+		if reason == "" && ctx.Err() != nil {
+			return "interrupted"
+		}
+		return reason
+	}
+	abort := func(r string) {
+		setReason(r)
+		cancelRun()
+	}
+	failures := int64(0)
+	if x.TotalDeadline > 0 {
+		elapsed := time.Now().Sub(startTime)
+		stop := func() {
+			setReason("out of time")
+			cancelStart()
+		}
+		if d := x.TotalDeadline - x.GracePeriod - elapsed; d > 0 {
+			defer time.AfterFunc(d, stop).Stop()
+		} else {
+			stop()
+		}
+		defer time.AfterFunc(x.TotalDeadline-elapsed, func() {
+			abort("out of time")
+		}).Stop()
+	}
+
+	// notRun sends a NOT_RUN result of a test that is not started.
+	notRun := func(t *xpytest_proto.TestQuery, reason string) {
+		pt := pytest.NewPytestWithQuery(x.PytestBase, t)
+		r := pytest.NewResult(pt, &xpytest_proto.TestResult{
+			Status: xpytest_proto.TestResult_NOT_RUN,
+		})
+		r.Reason = reason
+		send(t, r)
+	}
+	skip := func(t *xpytest_proto.TestQuery) {
+		notRun(t, getReason())
+	}
+
+	// Tests requiring more buckets than available cannot run because they
+	// must not get fewer devices than they require.
+	runnable := []*xpytest_proto.TestQuery{}
+	for _, t := range tests {
+		if k := numBuckets(t); k > len(capacities) {
+			notRun(t, fmt.Sprintf("requires %d buckets, but %d are available",
+				k, len(capacities)))
+			continue
+		}
+		runnable = append(runnable, t)
+	}
+
+	// stopped has files that do not run any more because of UntilFailure.
+	stopped := map[string]bool{}
+	stoppedMutex := sync.Mutex{}
+	isStopped := func(t *xpytest_proto.TestQuery) bool {
+		stoppedMutex.Lock()
+		defer stoppedMutex.Unlock()
+		return stopped[t.File]
+	}
+
+	// finish sends the final result of a test.
+	finish := func(t *xpytest_proto.TestQuery, r *pytest.Result) {
+		if x.UntilFailure && isFailure(r.Status) {
+			stoppedMutex.Lock()
+			stopped[t.File] = true
+			stoppedMutex.Unlock()
+		}
+		if isFailure(r.Status) && t.Quarantine == nil && x.MaxFailures > 0 &&
+			atomic.AddInt64(&failures, 1) >= int64(x.MaxFailures) {
+			abort(fmt.Sprintf("aborted after %d failures", x.MaxFailures))
+		}
+		send(t, r)
+	}
+
+	// run runs a test with the given resource usage.  If deferRetries is
+	// true, the test runs only once, recording its failed test cases.
+	wg := sync.WaitGroup{}
+	locks := newLockSet()
+	running := int64(0)
+	run := func(
+		t *xpytest_proto.TestQuery, usage *resourcebuckets.ResourceUsage,
+		deferRetries bool,
+	) *pytest.Result {
+		pt := pytest.NewPytestWithQuery(x.PytestBase, t)
+		if deferRetries {
+			pt.Retry = 1
+			pt.RecordFailedCases = pt.RetryFailedCases
+		}
+		if x.PassSeed {
+			pt.Args = append(append([]string{}, pt.Args...), x.seedArg())
+		}
+		for _, i := range usage.Indexes {
+			if c := buckets[i].Capacity; pt.Xdist > c {
+				pt.Xdist = c
 			}
 		}
-		x.Status = xpytest_proto.TestResult_SUCCESS
-		if len(flakyTests) > 0 {
-			fmt.Printf("\n%s\n", horizon("FLAKY TESTS"))
-			for _, t := range flakyTests {
-				fmt.Printf("%s\n", t.Summary())
-				if reporter != nil {
-					reporter.Log(ctx, t.Summary())
+		pt.Env = []string{
+			fmt.Sprintf("CUDA_VISIBLE_DEVICES=%s", func() string {
+				s := []string{}
+				if t.Buckets > 0 {
+					for _, i := range usage.Indexes {
+						s = append(s, buckets[i].Device)
+					}
+					return strings.Join(s, ",")
 				}
-			}
-			x.Status = xpytest_proto.TestResult_FLAKY
+				for i := range buckets {
+					s = append(s,
+						buckets[(i+usage.Index)%len(buckets)].Device)
+				}
+				return strings.Join(s, ",")
+			}()),
+		}
+		r, err := pt.Execute(runCtx)
+		if err != nil {
+			panic(fmt.Sprintf("failed execute pytest: %s: %s", t.File, err))
 		}
-		if len(failedTests) > 0 {
-			fmt.Printf("\n%s\n", horizon("FAILED TESTS"))
-			for _, t := range failedTests {
-				fmt.Printf("%s\n", t.Summary())
+		if r.Status == xpytest_proto.TestResult_NOT_RUN {
+			if ctx.Err() != nil {
+				r.Status = xpytest_proto.TestResult_INTERRUPTED
+			} else {
+				r.Reason = getReason()
 			}
-			x.Status = xpytest_proto.TestResult_FAILED
 		}
-		fmt.Printf("\n%s\n", horizon("TEST SUMMARY"))
-		fmt.Printf("%d failed, %d flaky, %d passed\n",
-			len(failedTests), len(flakyTests), len(passedTests))
-	}()
+		return r
+	}
 
-	wg := sync.WaitGroup{}
-	for _, t := range tests {
-		t := t
-		usage := rb.Acquire(func() int {
-			req := float64(resourceResolution)
-			if t.Xdist > 0 {
-				req *= float64(t.Xdist)
-			}
-			if t.Resource > 0 {
-				req *= float64(t.Resource)
+	// schedule starts the given tests with start, and it calls skip for
+	// tests that cannot start.  At most limit tests run at once unless limit
+	// is 0.  This returns once all the tests start or are skipped.
+	//
+	// The first pending test starts as soon as it fits.  Otherwise, it
+	// reserves a bucket, and the following tests start only if they do not
+	// delay the reservation so that they can use idle resources meanwhile.
+	// Tests waiting for locks are skipped, and the locks that they wait for
+	// are not given to the following tests so that they are not starved.
+	// If x.Shuffle is true, tests start strictly in the order of pending so
+	// that the same seed reproduces the same order.
+	schedule := func(
+		pending []*xpytest_proto.TestQuery, limit int,
+		start func(*xpytest_proto.TestQuery, *resourcebuckets.ResourceUsage),
+		skip func(*xpytest_proto.TestQuery),
+	) {
+		var reservation *resourcebuckets.Reservation
+		var reservedTest *xpytest_proto.TestQuery
+		defer func() {
+			if reservation != nil {
+				rb.Cancel(reservation)
 			}
-			return int(math.Ceil(req))
-		}())
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			defer rb.Release(usage)
-			pt := *x.PytestBase
-			pt.Files = []string{t.File}
-			pt.Xdist = int(t.Xdist)
-			if pt.Xdist > thread {
-				pt.Xdist = thread
+		}()
+		for len(pending) > 0 {
+			if startCtx.Err() != nil {
+				for _, t := range pending {
+					skip(t)
+				}
+				break
 			}
-			if t.Retry != 0 {
-				pt.Retry = int(t.Retry)
+			if limit > 0 && atomic.LoadInt64(&running) >= int64(limit) {
+				rb.Wait(startCtx)
+				continue
 			}
-			pt.Env = []string{
-				fmt.Sprintf("CUDA_VISIBLE_DEVICES=%s", func() string {
-					s := []string{}
-					for i := 0; i < bucket; i++ {
-						s = append(s, fmt.Sprintf("%d", (i+usage.Index)%bucket))
+			now := time.Now()
+			index := -1
+			var usage *resourcebuckets.ResourceUsage
+			head := true
+			wanted := map[string]bool{}
+			for i, t := range pending {
+				if x.Shuffle && i > 0 {
+					break
+				}
+				if !locks.available(t.Locks, wanted) {
+					for _, n := range t.Locks {
+						wanted[n] = true
 					}
-					return strings.Join(s, ",")
-				}()),
+					if t == reservedTest {
+						rb.Cancel(reservation)
+						reservation, reservedTest = nil, nil
+					}
+					continue
+				}
+				end := x.estimateEnd(t, now)
+				k := numBuckets(t)
+				req := resourceRequest(t, capacities, k)
+				if t == reservedTest {
+					usage = rb.TryAcquireReserved(reservation, end)
+				} else {
+					usage = rb.TryAcquire(k, req, end)
+				}
+				if usage != nil {
+					index = i
+					break
+				}
+				for _, n := range t.Locks {
+					wanted[n] = true
+				}
+				if head && t != reservedTest {
+					// NOTE: Reserve returns nil if the test can never fit.
+					reservation, reservedTest = rb.Reserve(k, req), nil
+					if reservation != nil {
+						reservedTest = t
+					}
+				}
+				head = false
 			}
-			if t.Deadline != 0 {
-				pt.Deadline = time.Duration(t.Deadline*1e6) * time.Microsecond
+			if index < 0 {
+				rb.Wait(startCtx)
+				continue
 			}
-			r, err := pt.Execute(ctx)
-			if err != nil {
-				panic(fmt.Sprintf("failed execute pytest: %s: %s", t.File, err))
+			t := pending[index]
+			if t == reservedTest {
+				reservation, reservedTest = nil, nil
 			}
-			resultChan <- r
-		}()
+			pending = append(pending[:index:index], pending[index+1:]...)
+			locks.lock(t.Locks)
+			atomic.AddInt64(&running, 1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer rb.Release(usage)
+				defer atomic.AddInt64(&running, -1)
+				defer locks.unlock(t.Locks)
+				start(t, usage)
+			}()
+		}
 	}
+
+	// In the main pass, failed tests that can be retried are deferred to the
+	// rerun phase if x.DeferRetries is true.
+	deferred := map[*xpytest_proto.TestQuery]*pytest.Result{}
+	reruns := []*xpytest_proto.TestQuery{}
+	rerunOf := map[*xpytest_proto.TestQuery]*xpytest_proto.TestQuery{}
+	deferredMutex := sync.Mutex{}
+	schedule(runnable, 0, func(
+		t *xpytest_proto.TestQuery, usage *resourcebuckets.ResourceUsage,
+	) {
+		if isStopped(t) {
+			// NOTE: A result is still sent so that groups of chunks complete
+			// and the skipped runs are counted.
+			notRun(t, "stopped after failure")
+			return
+		}
+		trials := pytest.NewPytestWithQuery(x.PytestBase, t).Retry
+		if !x.DeferRetries || trials <= 1 {
+			finish(t, run(t, usage, false))
+			return
+		}
+		r := run(t, usage, true)
+		if !pytest.IsRetriable(r.Status) {
+			finish(t, r)
+			return
+		}
+		rt := proto.Clone(t).(*xpytest_proto.TestQuery)
+		rt.Retry = int32(trials - 1)
+		if len(r.FailedCases()) > 0 {
+			rt.NodeIds = r.FailedCases()
+		}
+		if x.RerunWithoutXdist {
+			rt.Xdist = 0
+		}
+		deferredMutex.Lock()
+		defer deferredMutex.Unlock()
+		deferred[t] = r
+		reruns = append(reruns, rt)
+		rerunOf[rt] = t
+	}, skip)
 	wg.Wait()
+
+	// The rerun phase reruns the deferred tests in the order of the main
+	// pass.  A deferred test is FLAKY if it passes in the rerun phase, and it
+	// keeps the result of the main pass if it cannot rerun.
+	sort.SliceStable(reruns, func(i, j int) bool {
+		return indexOf(tests, rerunOf[reruns[i]]) <
+			indexOf(tests, rerunOf[reruns[j]])
+	})
+	schedule(reruns, x.RerunConcurrency, func(
+		rt *xpytest_proto.TestQuery, usage *resourcebuckets.ResourceUsage,
+	) {
+		t := rerunOf[rt]
+		r := deferred[t]
+		r.AddRerun(run(rt, usage, false))
+		finish(t, r)
+	}, func(rt *xpytest_proto.TestQuery) {
+		t := rerunOf[rt]
+		finish(t, deferred[t])
+	})
+	wg.Wait()
+	close(resultChan)
+	printer.Wait()
+	return nil
+}
+
+// ExecuteWithCoordinator runs tests on workers connected to the given
+// coordinator instead of running them locally.  This fails if options that
+// only Execute supports are set (e.g., MaxFailures).
+func (x *Xpytest) ExecuteWithCoordinator(
+	ctx context.Context, c *farm.Coordinator, reporter reporter.Reporter,
+) error {
+	if err := x.checkCoordinatorOptions(); err != nil {
+		return err
+	}
+	tests, chunkGroups := x.prepareTests(ctx)
+	if x.Shuffle || x.PassSeed {
+		x.resolveSeed()
+	}
+	if x.Shuffle {
+		x.shuffleTests(tests)
+	}
+	// Workers receive the seed through queries.  The queries are copied so
+	// that x.Tests are not modified.
+	originals := map[*xpytest_proto.TestQuery]*xpytest_proto.TestQuery{}
+	if x.PassSeed {
+		for i, t := range tests {
+			c := proto.Clone(t).(*xpytest_proto.TestQuery)
+			c.Args = append(c.Args, x.seedArg())
+			originals[c] = t
+			tests[i] = c
+		}
+	}
+	resultChan := make(chan *pytest.Result)
+
+	printer := sync.WaitGroup{}
+	printer.Add(1)
+	go func() {
+		defer printer.Done()
+		x.printResults(ctx, reporter, resultChan)
+	}()
+
+	err := c.Execute(ctx, tests, func(
+		t *xpytest_proto.TestQuery, tr *xpytest_proto.TestResult,
+	) {
+		if o, ok := originals[t]; ok {
+			t = o
+		}
+		r := pytest.NewResult(pytest.NewPytestWithQuery(x.PytestBase, t), tr)
+		if g, ok := chunkGroups[t]; ok {
+			if r = g.add(r); r == nil {
+				return
+			}
+		}
+		resultChan <- r
+	})
 	close(resultChan)
 	printer.Wait()
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to execute tests on workers: %s", err)
+	}
+	return nil
+}
+
+// checkCoordinatorOptions returns an error if any option that workers cannot
+// respect is set.
+func (x *Xpytest) checkCoordinatorOptions() error {
+	unsupported := ""
+	switch {
+	case x.MaxFailures > 0:
+		unsupported = "MaxFailures"
+	case x.TotalDeadline > 0:
+		unsupported = "TotalDeadline"
+	case x.DeferRetries:
+		unsupported = "DeferRetries"
+	case x.Repeat > 1:
+		unsupported = "Repeat"
+	}
+	for _, t := range x.GetTests() {
+		if unsupported != "" {
+			break
+		}
+		switch {
+		case len(t.Locks) > 0:
+			unsupported = fmt.Sprintf("locks of %s", t.File)
+		case t.Buckets > 1:
+			unsupported = fmt.Sprintf("buckets of %s", t.File)
+		case t.MemoryMb > 0:
+			unsupported = fmt.Sprintf("memory of %s", t.File)
+		case t.DeviceUnits > 0:
+			unsupported = fmt.Sprintf("device units of %s", t.File)
+		}
+	}
+	if unsupported != "" {
+		return fmt.Errorf(
+			"%s is not supported with a coordinator", unsupported)
+	}
 	return nil
 }
 
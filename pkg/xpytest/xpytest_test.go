@@ -2,11 +2,24 @@ package xpytest_test
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+
+	"github.com/chainer/xpytest/pkg/farm"
+	"github.com/chainer/xpytest/pkg/importgraph"
 	"github.com/chainer/xpytest/pkg/pytest"
 	"github.com/chainer/xpytest/pkg/xpytest"
 	xpytest_proto "github.com/chainer/xpytest/proto"
@@ -110,3 +123,1506 @@ func TestXpytestWithResourceMultiplier(t *testing.T) {
 	lock.Done()
 	testGroup.Wait()
 }
+
+func TestXpytestWithHistory(t *testing.T) {
+	ctx := context.Background()
+
+	files := []string{}
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			files = append(files, args[len(args)-1])
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.01 seconds ===",
+				Time:   float32(len(files)),
+				MaxRss: int64(len(files)) << 20,
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	for _, f := range []string{
+		"test_a.py", "test_b.py", "test_c.py", "test_d.py",
+	} {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:     f,
+			Deadline: 1.0,
+		})
+	}
+	// Tests whose durations are unknown are ordered by hint deadlines.
+	if err := xpt.ApplyHint(&xpytest_proto.HintFile{
+		Rules: []*xpytest_proto.HintFile_Rule{
+			{Name: "test_c.py", Deadline: 10},
+			{Name: "test_d.py", Deadline: 2},
+		},
+	}); err != nil {
+		t.Fatalf("failed to apply hint: %s", err)
+	}
+	h := &xpytest_proto.HistoryFile{
+		Entries: []*xpytest_proto.HistoryFile_Entry{
+			{Name: "test_a.py", Time: 1.0},
+			{Name: "test_b.py", Time: 3.0},
+		},
+	}
+	if err := xpt.ApplyHistory(h); err != nil {
+		t.Fatalf("failed to apply history: %s", err)
+	}
+	if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if s := strings.Join(files, ","); s !=
+		"test_c.py,test_b.py,test_d.py,test_a.py" {
+		t.Fatalf("unexpected order: %s", s)
+	}
+
+	if err := xpt.UpdateHistory(h); err != nil {
+		t.Fatalf("failed to update history: %s", err)
+	}
+	expected := `entries:<name:"test_a.py" time:2.5 max_rss:4194304 > ` +
+		`entries:<name:"test_b.py" time:2.5 max_rss:2097152 > ` +
+		`entries:<name:"test_c.py" time:1 max_rss:1048576 > ` +
+		`entries:<name:"test_d.py" time:3 max_rss:3145728 > `
+	if s := proto.CompactTextString(h); s != expected {
+		t.Fatalf("unexpected history: %s", s)
+	}
+}
+
+func TestXpytestUpdateHistoryWithUnreliableResults(t *testing.T) {
+	xpt := xpytest.NewXpytest(&pytest.Pytest{})
+	xpt.TestResults = []*xpytest_proto.TestResult{
+		{Name: "test_a.py", Status: xpytest_proto.TestResult_HUNG,
+			Time: 10, MaxRss: 4 << 20},
+		{Name: "test_b.py", Status: xpytest_proto.TestResult_LIMIT_EXCEEDED,
+			Time: 10, MaxRss: 8 << 20},
+		{Name: "test_c.py", Status: xpytest_proto.TestResult_NOT_RUN},
+		{Name: "test_d.py", Status: xpytest_proto.TestResult_SUCCESS,
+			Time: 2, MaxRss: 1 << 20},
+	}
+	h := &xpytest_proto.HistoryFile{
+		Entries: []*xpytest_proto.HistoryFile_Entry{
+			{Name: "test_a.py", Time: 1.0, MaxRss: 1 << 20},
+		},
+	}
+	if err := xpt.UpdateHistory(h); err != nil {
+		t.Fatalf("failed to update history: %s", err)
+	}
+	// Durations of unreliable results are not recorded.
+	expected := `entries:<name:"test_a.py" time:1 max_rss:4194304 > ` +
+		`entries:<name:"test_b.py" max_rss:8388608 > ` +
+		`entries:<name:"test_d.py" time:2 max_rss:1048576 > `
+	if s := proto.CompactTextString(h); s != expected {
+		t.Fatalf("unexpected history: %s", s)
+	}
+
+	// A duration is recorded as it is if no run finished normally.
+	xpt.TestResults = []*xpytest_proto.TestResult{
+		{Name: "test_b.py", Status: xpytest_proto.TestResult_FAILED,
+			Time: 3},
+	}
+	if err := xpt.UpdateHistory(h); err != nil {
+		t.Fatalf("failed to update history: %s", err)
+	}
+	if e := h.GetEntries()[1]; e.GetTime() != 3 || e.GetMaxRss() != 8<<20 {
+		t.Fatalf("unexpected history entry: %s", proto.CompactTextString(e))
+	}
+}
+
+func TestXpytestWithChunks(t *testing.T) {
+	ctx := context.Background()
+
+	mutex := sync.Mutex{}
+	chunks := []string{}
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			if args[3] == "--collect-only" {
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_SUCCESS,
+					Stdout: "test_foo.py::test_a\ntest_foo.py::test_b\n" +
+						"test_foo.py::test_c\n\n3 tests collected\n",
+				}, nil
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			chunks = append(chunks, strings.Join(args[3:], ","))
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: fmt.Sprintf("=== %d passed in 1.00 seconds ===",
+					len(args)-3),
+				Time: 1.0,
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	xpt.Tests = []*xpytest_proto.TestQuery{
+		{File: "test_foo.py", Deadline: 1.0, Chunks: 2},
+	}
+	if err := xpt.Execute(ctx, 1, 2, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	sort.Strings(chunks)
+	if s := strings.Join(chunks, " "); s !=
+		"test_foo.py::test_a test_foo.py::test_b,test_foo.py::test_c" {
+		t.Fatalf("unexpected chunks: %s", s)
+	}
+	if len(xpt.TestResults) != 1 {
+		t.Fatalf("unexpected # of results: %d", len(xpt.TestResults))
+	}
+	if r := xpt.TestResults[0]; r.Name != "test_foo.py" ||
+		r.Status != xpytest_proto.TestResult_SUCCESS || r.Time != 2.0 {
+		t.Fatalf("unexpected result: %s", r)
+	}
+}
+
+func TestXpytestWithChunkThreshold(t *testing.T) {
+	ctx := context.Background()
+
+	mutex := sync.Mutex{}
+	chunks := []string{}
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			if args[3] == "--collect-only" {
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_SUCCESS,
+					Stdout: args[4] + "::test_a\n" + args[4] + "::test_b\n",
+				}, nil
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			chunks = append(chunks, strings.Join(args[3:], ","))
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 1.00 seconds ===",
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	xpt.ChunkThreshold = 2 * time.Second
+	// An explicit chunk count of 1 disables splitting.
+	xpt.Tests = []*xpytest_proto.TestQuery{
+		{File: "test_foo.py", Deadline: 1.0, ExpectedDuration: 3.0},
+		{File: "test_bar.py", Deadline: 1.0, ExpectedDuration: 3.0,
+			Chunks: 1},
+	}
+	if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	sort.Strings(chunks)
+	if s := strings.Join(chunks, " "); s != "test_bar.py "+
+		"test_foo.py::test_a test_foo.py::test_b" {
+		t.Fatalf("unexpected chunks: %s", s)
+	}
+}
+
+func TestXpytestSelectShard(t *testing.T) {
+	deadlines := []float32{10, 1, 8, 3, 5, 4, 0, 0}
+	shards := []string{}
+	for i := 0; i < 3; i++ {
+		xpt := xpytest.NewXpytest(&pytest.Pytest{Deadline: 2 * time.Second})
+		for j, d := range deadlines {
+			xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+				File:     fmt.Sprintf("test_%d.py", j),
+				Deadline: d,
+			})
+		}
+		if err := xpt.SelectShard(i, 3); err != nil {
+			t.Fatalf("failed to select shard: %s", err)
+		}
+		files := []string{}
+		for _, tq := range xpt.GetTests() {
+			files = append(files, tq.File)
+		}
+		shards = append(shards, strings.Join(files, ","))
+	}
+	if s := strings.Join(shards, " "); s != "test_0.py,test_7.py "+
+		"test_1.py,test_2.py,test_3.py test_4.py,test_5.py,test_6.py" {
+		t.Fatalf("unexpected shards: %s", s)
+	}
+
+	xpt := xpytest.NewXpytest(&pytest.Pytest{})
+	if err := xpt.SelectShard(3, 3); err == nil {
+		t.Fatalf("shard index must be validated")
+	}
+}
+
+func TestXpytestSelectShardWithDifferentHistories(t *testing.T) {
+	// Each machine has a history of only the tests that it ran.
+	histories := []map[string]float32{
+		{"test_0.py": 30, "test_3.py": 1},
+		{"test_1.py": 2, "test_4.py": 50, "test_6.py": 7},
+		{},
+	}
+	counts := map[string]int{}
+	for i, history := range histories {
+		xpt := xpytest.NewXpytest(&pytest.Pytest{Deadline: time.Minute})
+		h := &xpytest_proto.HistoryFile{}
+		for j := 0; j < 8; j++ {
+			f := fmt.Sprintf("test_%d.py", j)
+			xpt.Tests = append(xpt.GetTests(),
+				&xpytest_proto.TestQuery{File: f})
+			if d, ok := history[f]; ok {
+				h.Entries = append(h.Entries,
+					&xpytest_proto.HistoryFile_Entry{Name: f, Time: d})
+			}
+		}
+		if err := xpt.ApplyHistory(h); err != nil {
+			t.Fatalf("failed to apply history: %s", err)
+		}
+		if err := xpt.SelectShard(i, len(histories)); err != nil {
+			t.Fatalf("failed to select shard: %s", err)
+		}
+		for _, tq := range xpt.GetTests() {
+			counts[tq.File]++
+		}
+	}
+	for j := 0; j < 8; j++ {
+		f := fmt.Sprintf("test_%d.py", j)
+		if counts[f] != 1 {
+			t.Errorf("unexpected # of shards running %s: %d", f, counts[f])
+		}
+	}
+	if len(counts) != 8 {
+		t.Fatalf("unexpected tests: %v", counts)
+	}
+}
+
+func TestXpytestWithMaxFailures(t *testing.T) {
+	ctx := context.Background()
+
+	total := int64(0)
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			atomic.AddInt64(&total, 1)
+			if args[len(args)-1] == "test_1.py" {
+				// This test keeps running until it is cancelled.
+				<-ctx.Done()
+			} else {
+				time.Sleep(10 * time.Millisecond)
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_FAILED,
+				Stdout: "=== 1 failed in 0.01 seconds ===",
+			}, nil
+		},
+		Retry: 2,
+	}
+	xpt := xpytest.NewXpytest(base)
+	xpt.MaxFailures = 1
+	for i := 0; i < 10; i++ {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:     fmt.Sprintf("test_%d.py", i),
+			Deadline: 1.0,
+		})
+	}
+	if err := xpt.Execute(ctx, 1, 2, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if total != 3 {
+		t.Fatalf("# of executions is unexpected: %d", total)
+	}
+	statuses := map[string]xpytest_proto.TestResult_Status{}
+	for _, r := range xpt.TestResults {
+		statuses[r.Name] = r.Status
+	}
+	if len(statuses) != 10 {
+		t.Fatalf("# of results is unexpected: %d", len(statuses))
+	}
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("test_%d.py", i)
+		expected := xpytest_proto.TestResult_NOT_RUN
+		if i == 0 {
+			expected = xpytest_proto.TestResult_FAILED
+		}
+		if statuses[name] != expected {
+			t.Errorf("unexpected status: %s: %s", name, statuses[name])
+		}
+	}
+	if xpt.Status != xpytest_proto.TestResult_FAILED {
+		t.Fatalf("unexpected status: %s", xpt.Status)
+	}
+}
+
+func TestXpytestWithTotalDeadline(t *testing.T) {
+	ctx := context.Background()
+
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			if args[len(args)-1] == "test_1.py" {
+				// This test keeps running until it is cancelled.
+				<-ctx.Done()
+			} else {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.05 seconds ===",
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	xpt.TotalDeadline = 300 * time.Millisecond
+	xpt.GracePeriod = 100 * time.Millisecond
+	for i := 0; i < 10; i++ {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:     fmt.Sprintf("test_%d.py", i),
+			Deadline: 1.0,
+		})
+	}
+	startTime := time.Now()
+	if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if d := time.Now().Sub(startTime); d > time.Second {
+		t.Fatalf("execution took too long: %s", d)
+	}
+	statuses := map[string]xpytest_proto.TestResult_Status{}
+	for _, r := range xpt.TestResults {
+		statuses[r.Name] = r.Status
+	}
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("test_%d.py", i)
+		expected := xpytest_proto.TestResult_NOT_RUN
+		if i == 0 {
+			expected = xpytest_proto.TestResult_SUCCESS
+		}
+		if statuses[name] != expected {
+			t.Errorf("unexpected status: %s: %s", name, statuses[name])
+		}
+	}
+
+	// Collection of test cases is also counted.
+	base.Executor = func(
+		ctx context.Context, args []string, d time.Duration, x []string,
+	) (*xpytest_proto.TestResult, error) {
+		if args[3] == "--collect-only" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &xpytest_proto.TestResult{
+			Status: xpytest_proto.TestResult_SUCCESS,
+			Stdout: "=== 1 passed in 0.05 seconds ===",
+		}, nil
+	}
+	xpt = xpytest.NewXpytest(base)
+	xpt.TotalDeadline = 300 * time.Millisecond
+	xpt.GracePeriod = 100 * time.Millisecond
+	xpt.Tests = []*xpytest_proto.TestQuery{
+		{File: "test_foo.py", Deadline: 1.0, Chunks: 2},
+	}
+	startTime = time.Now()
+	if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if d := time.Now().Sub(startTime); d > time.Second {
+		t.Fatalf("execution took too long: %s", d)
+	}
+	if len(xpt.TestResults) != 1 ||
+		xpt.TestResults[0].Status != xpytest_proto.TestResult_NOT_RUN {
+		t.Fatalf("unexpected results: %s", xpt.TestResults)
+	}
+
+	xpt = xpytest.NewXpytest(base)
+	xpt.TotalDeadline = 20 * time.Second
+	xpt.GracePeriod = 30 * time.Second
+	if err := xpt.Execute(ctx, 1, 1, nil); err == nil {
+		t.Fatalf("grace period longer than total deadline must be rejected")
+	}
+}
+
+func TestXpytestWithInterruption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			if args[len(args)-1] == "test_1.py" {
+				cancel()
+				<-ctx.Done()
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.01 seconds ===",
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	for i := 0; i < 5; i++ {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:     fmt.Sprintf("test_%d.py", i),
+			Deadline: 1.0,
+		})
+	}
+	if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	statuses := []string{}
+	for _, r := range xpt.TestResults {
+		statuses = append(statuses, fmt.Sprintf("%s:%s", r.Name, r.Status))
+	}
+	if s := strings.Join(statuses, ","); s != "test_0.py:SUCCESS,"+
+		"test_1.py:INTERRUPTED,test_2.py:NOT_RUN,test_3.py:NOT_RUN,"+
+		"test_4.py:NOT_RUN" {
+		t.Fatalf("unexpected results: %s", s)
+	}
+	if xpt.Status != xpytest_proto.TestResult_INTERRUPTED {
+		t.Fatalf("unexpected status: %s", xpt.Status)
+	}
+}
+
+func TestXpytestWithBuckets(t *testing.T) {
+	ctx := context.Background()
+
+	lock := sync.WaitGroup{}
+	lock.Add(3)
+	allRunning := make(chan struct{})
+	go func() {
+		lock.Wait()
+		close(allRunning)
+	}()
+	mutex := sync.Mutex{}
+	envs := []string{}
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			mutex.Lock()
+			envs = append(envs, strings.Join(x, " "))
+			mutex.Unlock()
+			// Every test waits for the others, so this fails unless all the
+			// tests run concurrently.
+			lock.Done()
+			select {
+			case <-allRunning:
+			case <-time.After(5 * time.Second):
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_FAILED,
+					Stdout: "=== 1 failed in 5.00 seconds ===",
+				}, nil
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.01 seconds ===",
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	buckets, err := xpytest.ParseBuckets("2:1,5:2")
+	if err != nil {
+		t.Fatalf("failed to parse buckets: %s", err)
+	}
+	xpt.Buckets = buckets
+	for i := 0; i < 3; i++ {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:     fmt.Sprintf("test_%d.py", i),
+			Deadline: 1.0,
+		})
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- xpt.Execute(ctx, 0, 0, nil)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("failed to execute: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("execution is hung")
+	}
+	if xpt.Status != xpytest_proto.TestResult_SUCCESS {
+		t.Fatalf("tests do not run concurrently: %s", xpt.Status)
+	}
+	sort.Strings(envs)
+	if s := strings.Join(envs, ","); s != "CUDA_VISIBLE_DEVICES=2,5,"+
+		"CUDA_VISIBLE_DEVICES=5,2,CUDA_VISIBLE_DEVICES=5,2" {
+		t.Fatalf("unexpected environment variables: %s", s)
+	}
+}
+
+func TestParseBuckets(t *testing.T) {
+	buckets, err := xpytest.ParseBuckets("2:8, 3:4:16000:2,7")
+	if err != nil {
+		t.Fatalf("failed to parse buckets: %s", err)
+	}
+	if s := fmt.Sprintf("%v", buckets); s !=
+		"[{2 8 0 0} {3 4 16000 2} {7 0 0 0}]" {
+		t.Fatalf("unexpected buckets: %s", s)
+	}
+	for _, spec := range []string{"", "2:x", "2:0", ":4", "2:1:1:1:1"} {
+		if _, err := xpytest.ParseBuckets(spec); err == nil {
+			t.Fatalf("invalid buckets are accepted: %q", spec)
+		}
+	}
+}
+
+func TestXpytestWithMemory(t *testing.T) {
+	ctx := context.Background()
+
+	running := int64(0)
+	maxRunning := int64(0)
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			defer atomic.AddInt64(&running, -1)
+			n := atomic.AddInt64(&running, 1)
+			for {
+				m := atomic.LoadInt64(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt64(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.02 seconds ===",
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	xpt.BucketMemoryMB = 1000
+	for i := 0; i < 10; i++ {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:     fmt.Sprintf("test_%d.py", i),
+			Deadline: 1.0,
+			MemoryMb: 400,
+		})
+	}
+	// CPU slots allow 4 tests to run concurrently, but memory allows only 2.
+	if err := xpt.Execute(ctx, 1, 4, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if maxRunning != 2 {
+		t.Fatalf("# of running jobs is unexpected: %d", maxRunning)
+	}
+}
+
+func TestXpytestWithBackfilling(t *testing.T) {
+	ctx := context.Background()
+
+	mutex := sync.Mutex{}
+	started := []string{}
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			file := args[len(args)-1]
+			mutex.Lock()
+			started = append(started, file)
+			mutex.Unlock()
+			if file == "test_long.py" {
+				time.Sleep(300 * time.Millisecond)
+			} else {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.05 seconds ===",
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+		File:             "test_long.py",
+		Deadline:         1.0,
+		Xdist:            2,
+		ExpectedDuration: 0.3,
+	}, &xpytest_proto.TestQuery{
+		File:             "test_large.py",
+		Deadline:         1.0,
+		Xdist:            4,
+		ExpectedDuration: 0.2,
+	})
+	for i := 0; i < 4; i++ {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:             fmt.Sprintf("test_small_%d.py", i),
+			Deadline:         1.0,
+			ExpectedDuration: 0.05,
+		})
+	}
+	if err := xpt.Execute(ctx, 1, 4, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	// Small tests use idle slots while test_large.py waits for test_long.py.
+	if len(started) != 6 || started[5] != "test_large.py" {
+		t.Fatalf("unexpected order: %s", strings.Join(started, ","))
+	}
+}
+
+func TestXpytestWithMultipleBuckets(t *testing.T) {
+	ctx := context.Background()
+
+	mutex := sync.Mutex{}
+	envs := map[string]string{}
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			mutex.Lock()
+			envs[args[len(args)-1]] = strings.Join(x, " ")
+			mutex.Unlock()
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.01 seconds ===",
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	buckets, err := xpytest.ParseBuckets("4:1,5:2,6:2")
+	if err != nil {
+		t.Fatalf("failed to parse buckets: %s", err)
+	}
+	xpt.Buckets = buckets
+	xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+		File:     "test_multi.py",
+		Deadline: 1.0,
+		Xdist:    2,
+		Buckets:  2,
+	}, &xpytest_proto.TestQuery{
+		File:     "test_all.py",
+		Deadline: 1.0,
+		Buckets:  3,
+	}, &xpytest_proto.TestQuery{
+		File:     "test_too_many.py",
+		Deadline: 1.0,
+		Buckets:  5,
+	})
+	if err := xpt.Execute(ctx, 0, 0, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	// Only the buckets of devices 5 and 6 have 2 threads.
+	if s := envs["test_multi.py"]; s != "CUDA_VISIBLE_DEVICES=5,6" {
+		t.Fatalf("unexpected environment variables: %s", s)
+	}
+	if s := envs["test_all.py"]; s != "CUDA_VISIBLE_DEVICES=5,6,4" {
+		t.Fatalf("unexpected environment variables: %s", s)
+	}
+	// A test must not get fewer buckets than it requires.
+	if s, ok := envs["test_too_many.py"]; ok {
+		t.Fatalf("test requiring too many buckets runs: %s", s)
+	}
+	for _, r := range xpt.TestResults {
+		if r.Name == "test_too_many.py" &&
+			r.Status != xpytest_proto.TestResult_NOT_RUN {
+			t.Fatalf("unexpected status: %s", r.Status)
+		}
+	}
+}
+
+func TestXpytestWithLocks(t *testing.T) {
+	ctx := context.Background()
+
+	locked := int64(0)
+	running := int64(0)
+	maxRunning := int64(0)
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			defer atomic.AddInt64(&running, -1)
+			if n := atomic.AddInt64(&running, 1); n > 1 {
+				atomic.StoreInt64(&maxRunning, n)
+			}
+			if strings.HasPrefix(args[len(args)-1], "test_cache_") {
+				defer atomic.AddInt64(&locked, -1)
+				if n := atomic.AddInt64(&locked, 1); n != 1 {
+					t.Errorf("tests holding the same lock run: %d", n)
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.02 seconds ===",
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	for i := 0; i < 3; i++ {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:     fmt.Sprintf("test_cache_%d.py", i),
+			Deadline: 1.0,
+		}, &xpytest_proto.TestQuery{
+			File:     fmt.Sprintf("test_other_%d.py", i),
+			Deadline: 1.0,
+		})
+	}
+	if err := xpt.ApplyHint(&xpytest_proto.HintFile{
+		Rules: []*xpytest_proto.HintFile_Rule{
+			{Name: "test_cache_0.py", Locks: []string{"cache"}},
+			{Name: "test_cache_1.py", Locks: []string{"cache"}},
+			{Name: "test_cache_2.py", Locks: []string{"cache", "fixture"}},
+		},
+	}); err != nil {
+		t.Fatalf("failed to apply hint: %s", err)
+	}
+	if err := xpt.Execute(ctx, 1, 4, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if len(xpt.TestResults) != 6 {
+		t.Fatalf("unexpected # of results: %d", len(xpt.TestResults))
+	}
+	if maxRunning <= 1 {
+		t.Fatalf("tests do not run in parallel: %d", maxRunning)
+	}
+}
+
+func TestXpytestWithIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	idleTimeouts := map[string]time.Duration{}
+	mutex := sync.Mutex{}
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			idleTimeout := pytest.ExecuteOptionsFromContext(ctx).IdleTimeout
+			mutex.Lock()
+			idleTimeouts[args[len(args)-1]] = idleTimeout
+			mutex.Unlock()
+			if args[len(args)-1] == "test_hang.py" {
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_HUNG,
+				}, nil
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.01 seconds ===",
+			}, nil
+		},
+		IdleTimeout: time.Minute,
+	}
+	xpt := xpytest.NewXpytest(base)
+	for _, f := range []string{"test_hang.py", "test_ok.py"} {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:     f,
+			Deadline: 1.0,
+		})
+	}
+	if err := xpt.ApplyHint(&xpytest_proto.HintFile{
+		Rules: []*xpytest_proto.HintFile_Rule{
+			{Name: "test_hang.py", IdleTimeout: 5},
+		},
+	}); err != nil {
+		t.Fatalf("failed to apply hint: %s", err)
+	}
+	if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if s := fmt.Sprint(idleTimeouts); s !=
+		"map[test_hang.py:5s test_ok.py:1m0s]" {
+		t.Fatalf("unexpected idle timeouts: %s", s)
+	}
+	if xpt.Status != xpytest_proto.TestResult_FAILED {
+		t.Fatalf("unexpected status: %s", xpt.Status)
+	}
+}
+
+func TestXpytestWithLimits(t *testing.T) {
+	ctx := context.Background()
+
+	limits := map[string]string{}
+	mutex := sync.Mutex{}
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			opts := pytest.ExecuteOptionsFromContext(ctx)
+			mutex.Lock()
+			limits[args[len(args)-1]] = fmt.Sprintf(
+				"%dMB/%s", opts.MemoryLimitMB, opts.CPUTimeLimit)
+			mutex.Unlock()
+			if args[len(args)-1] == "test_oom.py" {
+				return &xpytest_proto.TestResult{
+					Status:        xpytest_proto.TestResult_LIMIT_EXCEEDED,
+					ExceededLimit: "memory limit of 2048 MB",
+				}, nil
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.01 seconds ===",
+			}, nil
+		},
+		MemoryLimitMB: 1024,
+		CPUTimeLimit:  time.Minute,
+	}
+	xpt := xpytest.NewXpytest(base)
+	for _, f := range []string{"test_oom.py", "test_ok.py"} {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:     f,
+			Deadline: 1.0,
+		})
+	}
+	if err := xpt.ApplyHint(&xpytest_proto.HintFile{
+		Rules: []*xpytest_proto.HintFile_Rule{
+			{Name: "test_oom.py", MemoryLimitMb: 2048, CpuTimeLimit: 5},
+		},
+	}); err != nil {
+		t.Fatalf("failed to apply hint: %s", err)
+	}
+	if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if s := fmt.Sprint(limits); s !=
+		"map[test_ok.py:1024MB/1m0s test_oom.py:2048MB/5s]" {
+		t.Fatalf("unexpected limits: %s", s)
+	}
+	if xpt.Status != xpytest_proto.TestResult_FAILED {
+		t.Fatalf("unexpected status: %s", xpt.Status)
+	}
+}
+
+func TestXpytestWithDeferredRetries(t *testing.T) {
+	ctx := context.Background()
+
+	mutex := sync.Mutex{}
+	calls := []string{}
+	base := &pytest.Pytest{
+		Retry: 3,
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			file := args[len(args)-1]
+			call := []string{}
+			for _, a := range args[3:] {
+				if a != "-o" && !strings.HasPrefix(a, "cache_dir=") {
+					call = append(call, a)
+				}
+			}
+			calls = append(calls, strings.Join(call, " "))
+			n := 0
+			for _, c := range calls {
+				if strings.HasSuffix(c, file) {
+					n++
+				}
+			}
+			if file == "test_pass.py" || (file == "test_flaky.py" && n == 3) {
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_SUCCESS,
+					Stdout: "=== 1 passed in 0.01 seconds ===",
+				}, nil
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_FAILED,
+				Stdout: "=== 1 failed in 0.01 seconds ===",
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	xpt.DeferRetries = true
+	xpt.RerunConcurrency = 1
+	xpt.RerunWithoutXdist = true
+	for _, f := range []string{"test_fail.py", "test_flaky.py", "test_pass.py"} {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:     f,
+			Deadline: 1.0,
+			Xdist:    2,
+		})
+	}
+	if err := xpt.Execute(ctx, 1, 4, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	// All the tests run once before reruns, which run one by one without
+	// pytest-xdist.
+	sort.Strings(calls[:3])
+	if s := strings.Join(calls, ","); s != "-n 2 test_fail.py,"+
+		"-n 2 test_flaky.py,-n 2 test_pass.py,test_fail.py,test_fail.py,"+
+		"test_flaky.py,test_flaky.py" {
+		t.Fatalf("unexpected calls: %s", s)
+	}
+	statuses := []string{}
+	for _, r := range xpt.TestResults {
+		statuses = append(statuses, fmt.Sprintf("%s:%s", r.Name, r.Status))
+	}
+	sort.Strings(statuses)
+	if s := strings.Join(statuses, ","); s != "test_fail.py:FAILED,"+
+		"test_flaky.py:FLAKY,test_pass.py:SUCCESS" {
+		t.Fatalf("unexpected results: %s", s)
+	}
+	if xpt.Status != xpytest_proto.TestResult_FAILED {
+		t.Fatalf("unexpected status: %s", xpt.Status)
+	}
+}
+
+func TestXpytestWithQuarantine(t *testing.T) {
+	ctx := context.Background()
+
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			if strings.HasSuffix(args[len(args)-1], "test_broken.py") {
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_FAILED,
+					Stdout: "=== 1 failed in 0.01 seconds ===",
+				}, nil
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.01 seconds ===",
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	xpt.MaxFailures = 1
+	for _, f := range []string{"foo/test_broken.py", "foo/test_ok.py"} {
+		xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+			File:     f,
+			Deadline: 1.0,
+		})
+	}
+	if err := xpt.ApplyHint(&xpytest_proto.HintFile{
+		Quarantines: []*xpytest_proto.HintFile_Quarantine{
+			{Name: "test_b*.py", Reason: "broken", Expiry: "2000-01-01"},
+		},
+	}); err != nil {
+		t.Fatalf("failed to apply hint: %s", err)
+	}
+	if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	statuses := []string{}
+	for _, r := range xpt.TestResults {
+		statuses = append(statuses, fmt.Sprintf("%s:%s", r.Name, r.Status))
+	}
+	sort.Strings(statuses)
+	if s := strings.Join(statuses, ","); s != "foo/test_broken.py:FAILED,"+
+		"foo/test_ok.py:SUCCESS" {
+		t.Fatalf("unexpected results: %s", s)
+	}
+	if xpt.Status != xpytest_proto.TestResult_SUCCESS {
+		t.Fatalf("unexpected status: %s", xpt.Status)
+	}
+
+	if err := xpt.ApplyHint(&xpytest_proto.HintFile{
+		Quarantines: []*xpytest_proto.HintFile_Quarantine{
+			{Name: "test_broken.py", Expiry: "tomorrow"},
+		},
+	}); err == nil {
+		t.Fatalf("invalid expiry is accepted")
+	}
+}
+
+func TestXpytestWithRepeat(t *testing.T) {
+	ctx := context.Background()
+
+	runs := map[string]int{}
+	mutex := sync.Mutex{}
+	base := &pytest.Pytest{
+		Retry: 3,
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			file := args[len(args)-1]
+			runs[file]++
+			// test_flaky.py fails every third run.
+			if file == "test_flaky.py" && runs[file]%3 == 0 {
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_FAILED,
+					Stdout: "=== 1 failed in 0.01 seconds ===",
+				}, nil
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 1 passed in 0.01 seconds ===",
+			}, nil
+		},
+	}
+	newXpytest := func() *xpytest.Xpytest {
+		xpt := xpytest.NewXpytest(base)
+		for _, f := range []string{"test_flaky.py", "test_stable.py"} {
+			xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+				File:     f,
+				Deadline: 1.0,
+			})
+		}
+		xpt.Repeat = 6
+		return xpt
+	}
+
+	xpt := newXpytest()
+	if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	// Failed runs are not retried.
+	if s := fmt.Sprint(runs); s != "map[test_flaky.py:6 test_stable.py:6]" {
+		t.Fatalf("unexpected runs: %s", s)
+	}
+	if xpt.Status != xpytest_proto.TestResult_FAILED {
+		t.Fatalf("unexpected status: %s", xpt.Status)
+	}
+
+	runs = map[string]int{}
+	xpt = newXpytest()
+	xpt.UntilFailure = true
+	if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if s := fmt.Sprint(runs); s != "map[test_flaky.py:3 test_stable.py:6]" {
+		t.Fatalf("unexpected runs: %s", s)
+	}
+	// Runs after the failure are reported as NOT_RUN.
+	statuses := map[string]int{}
+	for _, r := range xpt.TestResults {
+		statuses[fmt.Sprintf("%s:%s", r.Name, r.Status)]++
+	}
+	if s := fmt.Sprint(statuses); s != "map[test_flaky.py:FAILED:1 "+
+		"test_flaky.py:NOT_RUN:3 test_flaky.py:SUCCESS:2 "+
+		"test_stable.py:SUCCESS:6]" {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+func TestXpytestWithRepeatAndChunks(t *testing.T) {
+	ctx := context.Background()
+
+	base := &pytest.Pytest{
+		Executor: func(
+			ctx context.Context, args []string, d time.Duration, x []string,
+		) (*xpytest_proto.TestResult, error) {
+			if args[3] == "--collect-only" {
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_SUCCESS,
+					Stdout: "test_foo.py::test_a\ntest_foo.py::test_b\n" +
+						"test_foo.py::test_c\n\n3 tests collected\n",
+				}, nil
+			}
+			if args[len(args)-1] == "test_foo.py::test_a" {
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_FAILED,
+					Stdout: "=== 1 failed in 1.00 seconds ===",
+				}, nil
+			}
+			return &xpytest_proto.TestResult{
+				Status: xpytest_proto.TestResult_SUCCESS,
+				Stdout: "=== 2 passed in 1.00 seconds ===",
+			}, nil
+		},
+	}
+	xpt := xpytest.NewXpytest(base)
+	xpt.Tests = []*xpytest_proto.TestQuery{
+		{File: "test_foo.py", Deadline: 1.0, Chunks: 2},
+	}
+	xpt.Repeat = 3
+	xpt.UntilFailure = true
+	if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	// The failed run must be reported even if the other chunk of the run is
+	// skipped.
+	statuses := []string{}
+	for _, r := range xpt.TestResults {
+		statuses = append(statuses, fmt.Sprintf("%s:%s", r.Name, r.Status))
+	}
+	sort.Strings(statuses)
+	if s := strings.Join(statuses, ","); s != "test_foo.py:FAILED,"+
+		"test_foo.py:NOT_RUN,test_foo.py:NOT_RUN" {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+func TestXpytestWithShuffle(t *testing.T) {
+	ctx := context.Background()
+
+	order := func(seed int64) string {
+		files := []string{}
+		base := &pytest.Pytest{
+			Executor: func(
+				ctx context.Context, args []string, d time.Duration,
+				x []string,
+			) (*xpytest_proto.TestResult, error) {
+				if s := args[len(args)-2]; s !=
+					fmt.Sprintf("--randomly-seed=%d", seed) {
+					t.Errorf("unexpected args: %s", args)
+				}
+				files = append(files, args[len(args)-1])
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_SUCCESS,
+					Stdout: "=== 1 passed in 0.01 seconds ===",
+				}, nil
+			},
+		}
+		xpt := xpytest.NewXpytest(base)
+		for i := 0; i < 10; i++ {
+			xpt.Tests = append(xpt.GetTests(), &xpytest_proto.TestQuery{
+				File:     fmt.Sprintf("test_%d.py", i),
+				Deadline: 1.0,
+			})
+		}
+		xpt.Shuffle = true
+		xpt.Seed = seed
+		xpt.PassSeed = true
+		if err := xpt.Execute(ctx, 1, 1, nil); err != nil {
+			t.Fatalf("failed to execute: %s", err)
+		}
+		return strings.Join(files, ",")
+	}
+
+	if a, b := order(1), order(1); a != b {
+		t.Fatalf("the same seed gives different orders: %s vs %s", a, b)
+	}
+	if a, b := order(1), order(2); a == b {
+		t.Fatalf("different seeds give the same order: %s", a)
+	}
+	if s := order(1); s == "test_0.py,test_1.py,test_2.py,test_3.py,"+
+		"test_4.py,test_5.py,test_6.py,test_7.py,test_8.py,test_9.py" {
+		t.Fatalf("tests are not shuffled: %s", s)
+	}
+}
+
+func TestXpytestWithShuffleAndBuckets(t *testing.T) {
+	ctx := context.Background()
+
+	order := func(seed int64) string {
+		mutex := sync.Mutex{}
+		started := []string{}
+		base := &pytest.Pytest{
+			Executor: func(
+				ctx context.Context, args []string, d time.Duration,
+				x []string,
+			) (*xpytest_proto.TestResult, error) {
+				mutex.Lock()
+				started = append(started, args[len(args)-1])
+				mutex.Unlock()
+				time.Sleep(50 * time.Millisecond)
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_SUCCESS,
+					Stdout: "=== 1 passed in 0.05 seconds ===",
+				}, nil
+			},
+		}
+		xpt := xpytest.NewXpytest(base)
+		xpt.BucketMemoryMB = 1000
+		// test_c could run along with test_a before test_b, but no two
+		// consecutive tests can run at once in the queue of the seed.
+		xpt.Tests = []*xpytest_proto.TestQuery{
+			{File: "test_a.py", Deadline: 1.0, MemoryMb: 600},
+			{File: "test_b.py", Deadline: 1.0, MemoryMb: 600, Xdist: 2},
+			{File: "test_c.py", Deadline: 0.1, MemoryMb: 100, Xdist: 2},
+		}
+		xpt.Shuffle = true
+		xpt.Seed = seed
+		if err := xpt.Execute(ctx, 1, 3, nil); err != nil {
+			t.Fatalf("failed to execute: %s", err)
+		}
+		return strings.Join(started, ",")
+	}
+
+	// Shuffled tests start in the order of the queue without backfilling.
+	for i := 0; i < 2; i++ {
+		if s := order(3); s != "test_a.py,test_b.py,test_c.py" {
+			t.Fatalf("unexpected order: %s", s)
+		}
+	}
+}
+
+func TestXpytestSelectAffectedTests(t *testing.T) {
+	selected := func(changed ...string) string {
+		xpt := xpytest.NewXpytest(&pytest.Pytest{})
+		for _, f := range []string{
+			"tests/test_foo.py", "tests/test_bar.py", "tests/sub/test_foo.py",
+			"tests/ext/test_kernel.py",
+		} {
+			xpt.Tests = append(xpt.GetTests(),
+				&xpytest_proto.TestQuery{File: f})
+		}
+		xpt.Mappings = []*xpytest_proto.HintFile_Mapping{
+			{Source: "src/ext/*.cu", Tests: []string{"tests/ext/test_*.py"}},
+			{Source: "util.py", Tests: []string{"test_bar.py"}},
+			{Source: "setup.cfg"},
+		}
+		xpt.SelectAffectedTests(changed)
+		files := []string{}
+		for _, tq := range xpt.GetTests() {
+			files = append(files, tq.File)
+		}
+		return strings.Join(files, ",")
+	}
+	if s := selected("tests/test_bar.py"); s != "tests/test_bar.py" {
+		t.Fatalf("unexpected tests for a test file: %s", s)
+	}
+	if s := selected("src/foo.py"); s !=
+		"tests/test_foo.py,tests/sub/test_foo.py" {
+		t.Fatalf("unexpected tests for a source file: %s", s)
+	}
+	if s := selected("src/ext/kernel.cu", "src/util.py", "README.md"); s !=
+		"tests/test_bar.py,tests/ext/test_kernel.py" {
+		t.Fatalf("unexpected tests for mapped files: %s", s)
+	}
+	// A source file without tests named after it may affect any test.
+	if s := selected("src/foo.py", "src/baz.py"); s != "tests/test_foo.py,"+
+		"tests/test_bar.py,tests/sub/test_foo.py,tests/ext/test_kernel.py" {
+		t.Fatalf("unexpected tests for an unknown source file: %s", s)
+	}
+	if s := selected("docs/index.rst", "setup.cfg"); s != "" {
+		t.Fatalf("unexpected tests for documents: %s", s)
+	}
+	// An unknown file other than Python files may affect any test.
+	if s := selected("src/foo.py", "src/foo.h"); s != "tests/test_foo.py,"+
+		"tests/test_bar.py,tests/sub/test_foo.py,tests/ext/test_kernel.py" {
+		t.Fatalf("unexpected tests for an unknown file: %s", s)
+	}
+}
+
+func TestXpytestSelectAffectedTestsWithImportGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xpytest")
+	if err != nil {
+		t.Fatalf("failed to create a directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	for name, src := range map[string]string{
+		"foo.py":            "import bar",
+		"bar.py":            "",
+		"tests/test_foo.py": "import foo",
+		"tests/test_bar.py": "from tests import test_foo",
+		"tests/test_baz.py": "",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create a directory: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatalf("failed to write a file: %s", err)
+		}
+	}
+	g, err := importgraph.Build(dir)
+	if err != nil {
+		t.Fatalf("failed to build a graph: %s", err)
+	}
+
+	selected := func(changed ...string) string {
+		xpt := xpytest.NewXpytest(&pytest.Pytest{})
+		for _, f := range []string{
+			"tests/test_foo.py", "tests/test_bar.py", "tests/test_baz.py",
+		} {
+			xpt.Tests = append(xpt.GetTests(),
+				&xpytest_proto.TestQuery{File: filepath.FromSlash(f)})
+		}
+		xpt.ImportGraph = g
+		xpt.SelectAffectedTests(changed)
+		files := []string{}
+		for _, tq := range xpt.GetTests() {
+			files = append(files, filepath.ToSlash(tq.File))
+		}
+		return strings.Join(files, ",")
+	}
+	if s := selected("bar.py"); s != "tests/test_foo.py,tests/test_bar.py" {
+		t.Fatalf("unexpected tests for a source file: %s", s)
+	}
+	if s := selected("tests/test_foo.py"); s !=
+		"tests/test_foo.py,tests/test_bar.py" {
+		t.Fatalf("unexpected tests for a test file: %s", s)
+	}
+	// Unlike names, an import graph can tell that no tests are affected.
+	if s := selected("unused.py"); s != "" {
+		t.Fatalf("unexpected tests for an unused file: %s", s)
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xpytest")
+	if err != nil {
+		t.Fatalf("failed to create a directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{
+			"-c", "user.name=xpytest", "-c", "user.email=xpytest@localhost",
+		}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("failed to run git: %s: %s", err, out)
+		}
+	}
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create a directory: %s", err)
+		}
+		if err := ioutil.WriteFile(
+			path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write a file: %s", err)
+		}
+	}
+	run("init", "-q")
+	write("foo.py", "x")
+	write("sub/bar.py", "x")
+	run("add", "-A")
+	run("commit", "-q", "-m", "base")
+	run("tag", "base")
+	write("sub/test_bar.py", "x")
+	run("add", "-A")
+	run("commit", "-q", "-m", "change")
+	// Uncommitted changes and untracked files are also included.
+	write("foo.py", "y")
+	write("sub/test_baz.py", "x")
+	write(".gitignore", "*.pyc\n")
+	write("sub/bar.pyc", "x")
+
+	files, err := xpytest.ChangedFiles(dir, "base")
+	if err != nil {
+		t.Fatalf("failed to get changed files: %s", err)
+	}
+	if s := fmt.Sprint(files); s !=
+		"[.gitignore foo.py sub/test_bar.py sub/test_baz.py]" {
+		t.Fatalf("unexpected changed files: %s", s)
+	}
+	files, err = xpytest.ChangedFiles(filepath.Join(dir, "sub"), "base")
+	if err != nil {
+		t.Fatalf("failed to get changed files: %s", err)
+	}
+	if s := fmt.Sprint(files); s != "[test_bar.py test_baz.py]" {
+		t.Fatalf("unexpected changed files in a subdirectory: %s", s)
+	}
+	if _, err := xpytest.ChangedFiles(dir, "no_such_ref"); err == nil {
+		t.Fatalf("unknown reference must be an error")
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	if s := fmt.Sprint(xpytest.WatchDirs([]string{
+		"tests/**/test_*.py", "tests/foo/test_*.py", "test_*.py",
+	})); s != "[tests tests/foo .]" {
+		t.Fatalf("unexpected directories: %s", s)
+	}
+
+	dir, err := ioutil.TempDir("", "xpytest")
+	if err != nil {
+		t.Fatalf("failed to create a directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create a directory: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("failed to write a file: %s", err)
+		}
+	}
+	write("foo.py")
+	write("test_foo.py")
+
+	w := xpytest.NewWatcher([]string{dir})
+	w.Interval = 50 * time.Millisecond
+	if err := w.Snapshot(); err != nil {
+		t.Fatalf("failed to take a snapshot: %s", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		write("foo.py")
+		write("bar.txt")
+		write("__pycache__/foo.py")
+		write("sub/test_bar.py")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changed, err := w.Wait(ctx)
+	if err != nil {
+		t.Fatalf("failed to wait: %s", err)
+	}
+	for i, f := range changed {
+		changed[i], _ = filepath.Rel(dir, f)
+	}
+	if s := fmt.Sprint(changed); s != "[foo.py sub/test_bar.py]" {
+		t.Fatalf("unexpected changed files: %s", s)
+	}
+}
+
+func TestXpytestExecuteWithCoordinatorAndUnsupportedOptions(t *testing.T) {
+	ctx := context.Background()
+	for name, f := range map[string]func(*xpytest.Xpytest){
+		"max_failures":  func(x *xpytest.Xpytest) { x.MaxFailures = 1 },
+		"defer_retries": func(x *xpytest.Xpytest) { x.DeferRetries = true },
+		"repeat":        func(x *xpytest.Xpytest) { x.Repeat = 3 },
+		"total_deadline": func(x *xpytest.Xpytest) {
+			x.TotalDeadline = time.Hour
+		},
+		"locks": func(x *xpytest.Xpytest) {
+			x.Tests[0].Locks = []string{"cache"}
+		},
+		"buckets": func(x *xpytest.Xpytest) { x.Tests[0].Buckets = 2 },
+		"memory":  func(x *xpytest.Xpytest) { x.Tests[0].MemoryMb = 1024 },
+		"device_units": func(x *xpytest.Xpytest) {
+			x.Tests[0].DeviceUnits = 1
+		},
+	} {
+		xpt := xpytest.NewXpytest(&pytest.Pytest{Deadline: time.Second})
+		xpt.Tests = []*xpytest_proto.TestQuery{{File: "test_foo.py"}}
+		f(xpt)
+		c := farm.NewCoordinator()
+		if err := xpt.ExecuteWithCoordinator(ctx, c, nil); err == nil {
+			t.Errorf("%s must be rejected", name)
+		}
+	}
+}
+
+func TestXpytestExecuteWithCoordinatorAndSeed(t *testing.T) {
+	ctx := context.Background()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	c := farm.NewCoordinator()
+	s := grpc.NewServer()
+	xpytest_proto.RegisterFarmServer(s, c)
+	go s.Serve(lis)
+	defer s.Stop()
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+
+	mutex := sync.Mutex{}
+	calls := []string{}
+	w := farm.NewWorker("worker", xpytest_proto.NewFarmClient(conn),
+		&pytest.Pytest{
+			Deadline: time.Second,
+			Executor: func(
+				ctx context.Context, args []string, d time.Duration,
+				x []string,
+			) (*xpytest_proto.TestResult, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				calls = append(calls, strings.Join(args[3:], " "))
+				return &xpytest_proto.TestResult{
+					Status: xpytest_proto.TestResult_SUCCESS,
+					Stdout: "=== 1 passed in 0.01 seconds ===",
+				}, nil
+			},
+		})
+	w.PollInterval = 10 * time.Millisecond
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go w.Run(workerCtx)
+
+	xpt := xpytest.NewXpytest(&pytest.Pytest{Deadline: time.Second})
+	xpt.Tests = []*xpytest_proto.TestQuery{{File: "test_foo.py"}}
+	xpt.Seed = 123
+	xpt.PassSeed = true
+	if err := xpt.ExecuteWithCoordinator(ctx, c, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if s := strings.Join(calls, ","); s != "--randomly-seed=123 test_foo.py" {
+		t.Fatalf("unexpected calls: %s", s)
+	}
+	if len(xpt.Tests[0].Args) != 0 {
+		t.Fatalf("tests are modified: %s", xpt.Tests[0])
+	}
+}
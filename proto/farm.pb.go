@@ -0,0 +1,458 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: xpytest/proto/farm.proto
+
+package xpytest_proto
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type LeaseRequest struct {
+	// Worker name, which must be unique among workers.
+	Worker               string   `protobuf:"bytes,1,opt,name=worker,proto3" json:"worker,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LeaseRequest) Reset()         { *m = LeaseRequest{} }
+func (m *LeaseRequest) String() string { return proto.CompactTextString(m) }
+func (*LeaseRequest) ProtoMessage()    {}
+func (*LeaseRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_farm_7f49127be4342da2, []int{0}
+}
+func (m *LeaseRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LeaseRequest.Unmarshal(m, b)
+}
+func (m *LeaseRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LeaseRequest.Marshal(b, m, deterministic)
+}
+func (dst *LeaseRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeaseRequest.Merge(dst, src)
+}
+func (m *LeaseRequest) XXX_Size() int {
+	return xxx_messageInfo_LeaseRequest.Size(m)
+}
+func (m *LeaseRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeaseRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LeaseRequest proto.InternalMessageInfo
+
+func (m *LeaseRequest) GetWorker() string {
+	if m != nil {
+		return m.Worker
+	}
+	return ""
+}
+
+type LeaseResponse struct {
+	// Lease ID, which should be given when reporting a result.
+	LeaseId string `protobuf:"bytes,1,opt,name=lease_id,json=leaseId,proto3" json:"lease_id,omitempty"`
+	// Leased test.  This is unset if no tests are available for now.
+	Test *TestQuery `protobuf:"bytes,2,opt,name=test,proto3" json:"test,omitempty"`
+	// True if all tests are finished.  A worker should exit then.
+	Finished             bool     `protobuf:"varint,3,opt,name=finished,proto3" json:"finished,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LeaseResponse) Reset()         { *m = LeaseResponse{} }
+func (m *LeaseResponse) String() string { return proto.CompactTextString(m) }
+func (*LeaseResponse) ProtoMessage()    {}
+func (*LeaseResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_farm_7f49127be4342da2, []int{1}
+}
+func (m *LeaseResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LeaseResponse.Unmarshal(m, b)
+}
+func (m *LeaseResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LeaseResponse.Marshal(b, m, deterministic)
+}
+func (dst *LeaseResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeaseResponse.Merge(dst, src)
+}
+func (m *LeaseResponse) XXX_Size() int {
+	return xxx_messageInfo_LeaseResponse.Size(m)
+}
+func (m *LeaseResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeaseResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LeaseResponse proto.InternalMessageInfo
+
+func (m *LeaseResponse) GetLeaseId() string {
+	if m != nil {
+		return m.LeaseId
+	}
+	return ""
+}
+
+func (m *LeaseResponse) GetTest() *TestQuery {
+	if m != nil {
+		return m.Test
+	}
+	return nil
+}
+
+func (m *LeaseResponse) GetFinished() bool {
+	if m != nil {
+		return m.Finished
+	}
+	return false
+}
+
+type ReportRequest struct {
+	// Worker name.
+	Worker string `protobuf:"bytes,1,opt,name=worker,proto3" json:"worker,omitempty"`
+	// Lease ID given by Lease.
+	LeaseId string `protobuf:"bytes,2,opt,name=lease_id,json=leaseId,proto3" json:"lease_id,omitempty"`
+	// Result of the leased test.
+	Result               *TestResult `protobuf:"bytes,3,opt,name=result,proto3" json:"result,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *ReportRequest) Reset()         { *m = ReportRequest{} }
+func (m *ReportRequest) String() string { return proto.CompactTextString(m) }
+func (*ReportRequest) ProtoMessage()    {}
+func (*ReportRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_farm_7f49127be4342da2, []int{2}
+}
+func (m *ReportRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReportRequest.Unmarshal(m, b)
+}
+func (m *ReportRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReportRequest.Marshal(b, m, deterministic)
+}
+func (dst *ReportRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReportRequest.Merge(dst, src)
+}
+func (m *ReportRequest) XXX_Size() int {
+	return xxx_messageInfo_ReportRequest.Size(m)
+}
+func (m *ReportRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReportRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReportRequest proto.InternalMessageInfo
+
+func (m *ReportRequest) GetWorker() string {
+	if m != nil {
+		return m.Worker
+	}
+	return ""
+}
+
+func (m *ReportRequest) GetLeaseId() string {
+	if m != nil {
+		return m.LeaseId
+	}
+	return ""
+}
+
+func (m *ReportRequest) GetResult() *TestResult {
+	if m != nil {
+		return m.Result
+	}
+	return nil
+}
+
+type ReportResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReportResponse) Reset()         { *m = ReportResponse{} }
+func (m *ReportResponse) String() string { return proto.CompactTextString(m) }
+func (*ReportResponse) ProtoMessage()    {}
+func (*ReportResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_farm_7f49127be4342da2, []int{3}
+}
+func (m *ReportResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReportResponse.Unmarshal(m, b)
+}
+func (m *ReportResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReportResponse.Marshal(b, m, deterministic)
+}
+func (dst *ReportResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReportResponse.Merge(dst, src)
+}
+func (m *ReportResponse) XXX_Size() int {
+	return xxx_messageInfo_ReportResponse.Size(m)
+}
+func (m *ReportResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReportResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReportResponse proto.InternalMessageInfo
+
+type HeartbeatRequest struct {
+	// Worker name.
+	Worker               string   `protobuf:"bytes,1,opt,name=worker,proto3" json:"worker,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HeartbeatRequest) Reset()         { *m = HeartbeatRequest{} }
+func (m *HeartbeatRequest) String() string { return proto.CompactTextString(m) }
+func (*HeartbeatRequest) ProtoMessage()    {}
+func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_farm_7f49127be4342da2, []int{4}
+}
+func (m *HeartbeatRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HeartbeatRequest.Unmarshal(m, b)
+}
+func (m *HeartbeatRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HeartbeatRequest.Marshal(b, m, deterministic)
+}
+func (dst *HeartbeatRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HeartbeatRequest.Merge(dst, src)
+}
+func (m *HeartbeatRequest) XXX_Size() int {
+	return xxx_messageInfo_HeartbeatRequest.Size(m)
+}
+func (m *HeartbeatRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_HeartbeatRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HeartbeatRequest proto.InternalMessageInfo
+
+func (m *HeartbeatRequest) GetWorker() string {
+	if m != nil {
+		return m.Worker
+	}
+	return ""
+}
+
+type HeartbeatResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HeartbeatResponse) Reset()         { *m = HeartbeatResponse{} }
+func (m *HeartbeatResponse) String() string { return proto.CompactTextString(m) }
+func (*HeartbeatResponse) ProtoMessage()    {}
+func (*HeartbeatResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_farm_7f49127be4342da2, []int{5}
+}
+func (m *HeartbeatResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HeartbeatResponse.Unmarshal(m, b)
+}
+func (m *HeartbeatResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HeartbeatResponse.Marshal(b, m, deterministic)
+}
+func (dst *HeartbeatResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HeartbeatResponse.Merge(dst, src)
+}
+func (m *HeartbeatResponse) XXX_Size() int {
+	return xxx_messageInfo_HeartbeatResponse.Size(m)
+}
+func (m *HeartbeatResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_HeartbeatResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HeartbeatResponse proto.InternalMessageInfo
+
+func init() {
+	proto.RegisterType((*LeaseRequest)(nil), "xpytest.proto.LeaseRequest")
+	proto.RegisterType((*LeaseResponse)(nil), "xpytest.proto.LeaseResponse")
+	proto.RegisterType((*ReportRequest)(nil), "xpytest.proto.ReportRequest")
+	proto.RegisterType((*ReportResponse)(nil), "xpytest.proto.ReportResponse")
+	proto.RegisterType((*HeartbeatRequest)(nil), "xpytest.proto.HeartbeatRequest")
+	proto.RegisterType((*HeartbeatResponse)(nil), "xpytest.proto.HeartbeatResponse")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// FarmClient is the client API for Farm service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type FarmClient interface {
+	// Lease leases a test to a worker.
+	Lease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*LeaseResponse, error)
+	// Report reports a result of a leased test.
+	Report(ctx context.Context, in *ReportRequest, opts ...grpc.CallOption) (*ReportResponse, error)
+	// Heartbeat tells a coordinator that a worker is alive.  Tests leased to a
+	// worker are leased to other workers if the worker stops sending
+	// heartbeats.
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+}
+
+type farmClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewFarmClient(cc *grpc.ClientConn) FarmClient {
+	return &farmClient{cc}
+}
+
+func (c *farmClient) Lease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*LeaseResponse, error) {
+	out := new(LeaseResponse)
+	err := c.cc.Invoke(ctx, "/xpytest.proto.Farm/Lease", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *farmClient) Report(ctx context.Context, in *ReportRequest, opts ...grpc.CallOption) (*ReportResponse, error) {
+	out := new(ReportResponse)
+	err := c.cc.Invoke(ctx, "/xpytest.proto.Farm/Report", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *farmClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, "/xpytest.proto.Farm/Heartbeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FarmServer is the server API for Farm service.
+type FarmServer interface {
+	// Lease leases a test to a worker.
+	Lease(context.Context, *LeaseRequest) (*LeaseResponse, error)
+	// Report reports a result of a leased test.
+	Report(context.Context, *ReportRequest) (*ReportResponse, error)
+	// Heartbeat tells a coordinator that a worker is alive.  Tests leased to a
+	// worker are leased to other workers if the worker stops sending
+	// heartbeats.
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+}
+
+func RegisterFarmServer(s *grpc.Server, srv FarmServer) {
+	s.RegisterService(&_Farm_serviceDesc, srv)
+}
+
+func _Farm_Lease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FarmServer).Lease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/xpytest.proto.Farm/Lease",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FarmServer).Lease(ctx, req.(*LeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Farm_Report_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FarmServer).Report(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/xpytest.proto.Farm/Report",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FarmServer).Report(ctx, req.(*ReportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Farm_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FarmServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/xpytest.proto.Farm/Heartbeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FarmServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Farm_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "xpytest.proto.Farm",
+	HandlerType: (*FarmServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lease",
+			Handler:    _Farm_Lease_Handler,
+		},
+		{
+			MethodName: "Report",
+			Handler:    _Farm_Report_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _Farm_Heartbeat_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "xpytest/proto/farm.proto",
+}
+
+func init() { proto.RegisterFile("xpytest/proto/farm.proto", fileDescriptor_farm_7f49127be4342da2) }
+
+var fileDescriptor_farm_7f49127be4342da2 = []byte{
+	// 304 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x91, 0xd1, 0x4a, 0xc3, 0x30,
+	0x14, 0x86, 0xe9, 0x9c, 0x75, 0x3b, 0xb3, 0x32, 0x23, 0x48, 0x57, 0x1d, 0x96, 0x5c, 0x48, 0x11,
+	0xe9, 0xb0, 0xbe, 0x81, 0xa0, 0x28, 0x88, 0x60, 0xf0, 0x7e, 0x64, 0xf6, 0x0c, 0x8b, 0xdb, 0x5a,
+	0x93, 0x14, 0xdd, 0xe3, 0xfa, 0x26, 0xd2, 0x24, 0x8e, 0xb5, 0x6c, 0xec, 0x2e, 0xa7, 0xff, 0xc7,
+	0x9f, 0x2f, 0xa7, 0xe0, 0xff, 0x14, 0x4b, 0x85, 0x52, 0x8d, 0x0a, 0x91, 0xab, 0x7c, 0x34, 0xe5,
+	0x62, 0x1e, 0xeb, 0x23, 0xf1, 0x6c, 0x62, 0xc6, 0x60, 0x58, 0x07, 0xab, 0xe3, 0xf8, 0x9d, 0x4b,
+	0x34, 0x31, 0xbd, 0x84, 0xc3, 0x67, 0xe4, 0x12, 0x19, 0x7e, 0x95, 0x28, 0x15, 0x39, 0x05, 0xf7,
+	0x3b, 0x17, 0x9f, 0x28, 0x7c, 0x27, 0x74, 0xa2, 0x2e, 0xb3, 0x13, 0x55, 0xe0, 0x59, 0x4e, 0x16,
+	0xf9, 0x42, 0x22, 0x19, 0x40, 0x67, 0x56, 0x7d, 0x18, 0x67, 0xa9, 0x45, 0x0f, 0xf4, 0xfc, 0x94,
+	0x92, 0x6b, 0x68, 0x57, 0xd7, 0xf8, 0xad, 0xd0, 0x89, 0x7a, 0x89, 0x1f, 0xd7, 0x84, 0xe2, 0x37,
+	0x94, 0xea, 0xb5, 0x44, 0xb1, 0x64, 0x9a, 0x22, 0x01, 0x74, 0xa6, 0xd9, 0x22, 0x93, 0x1f, 0x98,
+	0xfa, 0x7b, 0xa1, 0x13, 0x75, 0xd8, 0x6a, 0xa6, 0x25, 0x78, 0x0c, 0x8b, 0x5c, 0xa8, 0x1d, 0x7a,
+	0x35, 0x9b, 0x56, 0xdd, 0xe6, 0x06, 0x5c, 0x81, 0xb2, 0x9c, 0x29, 0xdd, 0xde, 0x4b, 0x06, 0x1b,
+	0x7c, 0x98, 0x06, 0x98, 0x05, 0x69, 0x1f, 0x8e, 0xfe, 0xaf, 0x35, 0xaf, 0xa5, 0x57, 0xd0, 0x7f,
+	0x44, 0x2e, 0xd4, 0x04, 0xf9, 0x2e, 0x17, 0x7a, 0x02, 0xc7, 0x6b, 0xac, 0x29, 0x48, 0x7e, 0x1d,
+	0x68, 0x3f, 0x70, 0x31, 0x27, 0x77, 0xb0, 0xaf, 0x17, 0x49, 0xce, 0x1a, 0x1e, 0xeb, 0xbf, 0x21,
+	0x38, 0xdf, 0x1c, 0xda, 0xdd, 0xdf, 0x83, 0x6b, 0xfc, 0x48, 0x93, 0xab, 0x6d, 0x2b, 0x18, 0x6e,
+	0x49, 0x6d, 0xcd, 0x0b, 0x74, 0x57, 0xa2, 0xe4, 0xa2, 0xc1, 0x36, 0x9f, 0x1b, 0x84, 0xdb, 0x01,
+	0xd3, 0x37, 0x71, 0x75, 0x70, 0xfb, 0x37, 0x00, 0x9d, 0x05, 0x7a, 0x45, 0x9c, 0x02, 0x00, 0x00,
+}
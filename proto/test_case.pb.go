@@ -27,6 +27,16 @@ const (
 	TestResult_FAILED   TestResult_Status = 3
 	TestResult_TIMEOUT  TestResult_Status = 4
 	TestResult_FLAKY    TestResult_Status = 5
+	// The test did not run or was aborted (e.g., by --fail_fast or
+	// --total_deadline).
+	TestResult_NOT_RUN TestResult_Status = 6
+	// The test was interrupted by a signal (e.g., SIGINT).
+	TestResult_INTERRUPTED TestResult_Status = 7
+	// The test printed nothing for its idle timeout (e.g., because of a
+	// deadlock).
+	TestResult_HUNG TestResult_Status = 8
+	// The test exceeded its memory limit or CPU time limit.
+	TestResult_LIMIT_EXCEEDED TestResult_Status = 9
 )
 
 var TestResult_Status_name = map[int32]string{
@@ -36,21 +46,29 @@ var TestResult_Status_name = map[int32]string{
 	3: "FAILED",
 	4: "TIMEOUT",
 	5: "FLAKY",
+	6: "NOT_RUN",
+	7: "INTERRUPTED",
+	8: "HUNG",
+	9: "LIMIT_EXCEEDED",
 }
 var TestResult_Status_value = map[string]int32{
-	"UNKNOWN":  0,
-	"SUCCESS":  1,
-	"INTERNAL": 2,
-	"FAILED":   3,
-	"TIMEOUT":  4,
-	"FLAKY":    5,
+	"UNKNOWN":        0,
+	"SUCCESS":        1,
+	"INTERNAL":       2,
+	"FAILED":         3,
+	"TIMEOUT":        4,
+	"FLAKY":          5,
+	"NOT_RUN":        6,
+	"INTERRUPTED":    7,
+	"HUNG":           8,
+	"LIMIT_EXCEEDED": 9,
 }
 
 func (x TestResult_Status) String() string {
 	return proto.EnumName(TestResult_Status_name, int32(x))
 }
 func (TestResult_Status) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_test_case_044fbc67b1ebf751, []int{1, 0}
+	return fileDescriptor_test_case_76550c12846a8a99, []int{1, 0}
 }
 
 type TestQuery struct {
@@ -66,7 +84,47 @@ type TestQuery struct {
 	// # of retries.
 	Retry int32 `protobuf:"varint,5,opt,name=retry,proto3" json:"retry,omitempty"`
 	// Resource usage multiplier.
-	Resource             float32  `protobuf:"fixed32,6,opt,name=resource,proto3" json:"resource,omitempty"`
+	Resource float32 `protobuf:"fixed32,6,opt,name=resource,proto3" json:"resource,omitempty"`
+	// Expected duration in seconds, estimated from previous runs (0 if
+	// unknown).
+	ExpectedDuration float32 `protobuf:"fixed32,7,opt,name=expected_duration,json=expectedDuration,proto3" json:"expected_duration,omitempty"`
+	// # of chunks.  If this is larger than 1, test cases in the file are
+	// collected and split into the chunks, each of which runs in a separate
+	// pytest process.
+	Chunks int32 `protobuf:"varint,8,opt,name=chunks,proto3" json:"chunks,omitempty"`
+	// Node IDs of test cases to run (e.g., "test_foo.py::test_bar").  All test
+	// cases in the file run if this is empty.
+	NodeIds []string `protobuf:"bytes,9,rep,name=node_ids,json=nodeIds,proto3" json:"node_ids,omitempty"`
+	// Memory in MB that the test uses in a bucket (0 if negligible).
+	MemoryMb int32 `protobuf:"varint,10,opt,name=memory_mb,json=memoryMb,proto3" json:"memory_mb,omitempty"`
+	// # of device units that the test uses in a bucket (0 if negligible).
+	DeviceUnits int32 `protobuf:"varint,11,opt,name=device_units,json=deviceUnits,proto3" json:"device_units,omitempty"`
+	// # of buckets that the test uses at once (e.g., for multi-GPU tests).
+	// Each of the buckets provides the resources above, and
+	// CUDA_VISIBLE_DEVICES lists exactly the devices of the buckets.  If this
+	// is 0, the test uses one bucket and CUDA_VISIBLE_DEVICES lists all the
+	// devices starting with the device of the bucket.  The test does not run if
+	// there are fewer buckets.
+	Buckets int32 `protobuf:"varint,12,opt,name=buckets,proto3" json:"buckets,omitempty"`
+	// Names of locks that the test holds while it runs.  Tests holding the same
+	// lock never run concurrently (e.g., tests sharing an on-disk cache).
+	Locks []string `protobuf:"bytes,13,rep,name=locks,proto3" json:"locks,omitempty"`
+	// Quarantine of the test if it is known to be flaky or broken.  A
+	// quarantined test runs, but its result does not affect the overall status.
+	Quarantine *HintFile_Quarantine `protobuf:"bytes,14,opt,name=quarantine,proto3" json:"quarantine,omitempty"`
+	// Idle timeout in seconds.  The test is terminated as HUNG if it prints
+	// nothing for this duration.  No idle timeout if this is 0.
+	IdleTimeout float32 `protobuf:"fixed32,15,opt,name=idle_timeout,json=idleTimeout,proto3" json:"idle_timeout,omitempty"`
+	// Limit of the address space in MB of each process of the test.  The test
+	// results in LIMIT_EXCEEDED if it fails with MemoryError.  No limit if this
+	// is 0.
+	MemoryLimitMb int32 `protobuf:"varint,16,opt,name=memory_limit_mb,json=memoryLimitMb,proto3" json:"memory_limit_mb,omitempty"`
+	// Limit of CPU time in seconds of each process of the test.  The test is
+	// terminated as LIMIT_EXCEEDED if it uses up the time.  No limit if this is
+	// 0.
+	CpuTimeLimit float32 `protobuf:"fixed32,17,opt,name=cpu_time_limit,json=cpuTimeLimit,proto3" json:"cpu_time_limit,omitempty"`
+	// Additional arguments given to pytest (e.g., "--randomly-seed=1").
+	Args                 []string `protobuf:"bytes,18,rep,name=args,proto3" json:"args,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -76,7 +134,7 @@ func (m *TestQuery) Reset()         { *m = TestQuery{} }
 func (m *TestQuery) String() string { return proto.CompactTextString(m) }
 func (*TestQuery) ProtoMessage()    {}
 func (*TestQuery) Descriptor() ([]byte, []int) {
-	return fileDescriptor_test_case_044fbc67b1ebf751, []int{0}
+	return fileDescriptor_test_case_76550c12846a8a99, []int{0}
 }
 func (m *TestQuery) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_TestQuery.Unmarshal(m, b)
@@ -138,6 +196,90 @@ func (m *TestQuery) GetResource() float32 {
 	return 0
 }
 
+func (m *TestQuery) GetExpectedDuration() float32 {
+	if m != nil {
+		return m.ExpectedDuration
+	}
+	return 0
+}
+
+func (m *TestQuery) GetChunks() int32 {
+	if m != nil {
+		return m.Chunks
+	}
+	return 0
+}
+
+func (m *TestQuery) GetNodeIds() []string {
+	if m != nil {
+		return m.NodeIds
+	}
+	return nil
+}
+
+func (m *TestQuery) GetMemoryMb() int32 {
+	if m != nil {
+		return m.MemoryMb
+	}
+	return 0
+}
+
+func (m *TestQuery) GetDeviceUnits() int32 {
+	if m != nil {
+		return m.DeviceUnits
+	}
+	return 0
+}
+
+func (m *TestQuery) GetBuckets() int32 {
+	if m != nil {
+		return m.Buckets
+	}
+	return 0
+}
+
+func (m *TestQuery) GetLocks() []string {
+	if m != nil {
+		return m.Locks
+	}
+	return nil
+}
+
+func (m *TestQuery) GetQuarantine() *HintFile_Quarantine {
+	if m != nil {
+		return m.Quarantine
+	}
+	return nil
+}
+
+func (m *TestQuery) GetIdleTimeout() float32 {
+	if m != nil {
+		return m.IdleTimeout
+	}
+	return 0
+}
+
+func (m *TestQuery) GetMemoryLimitMb() int32 {
+	if m != nil {
+		return m.MemoryLimitMb
+	}
+	return 0
+}
+
+func (m *TestQuery) GetCpuTimeLimit() float32 {
+	if m != nil {
+		return m.CpuTimeLimit
+	}
+	return 0
+}
+
+func (m *TestQuery) GetArgs() []string {
+	if m != nil {
+		return m.Args
+	}
+	return nil
+}
+
 type TestResult struct {
 	Status TestResult_Status `protobuf:"varint,1,opt,name=status,proto3,enum=xpytest.proto.TestResult_Status" json:"status,omitempty"`
 	// Test name (e.g., "tests/foo_tests/test_bar.py").
@@ -147,7 +289,36 @@ type TestResult struct {
 	// Standard error.
 	Stderr string `protobuf:"bytes,4,opt,name=stderr,proto3" json:"stderr,omitempty"`
 	// Duration that the test took.
-	Time                 float32  `protobuf:"fixed32,5,opt,name=time,proto3" json:"time,omitempty"`
+	Time float32 `protobuf:"fixed32,5,opt,name=time,proto3" json:"time,omitempty"`
+	// # of stray processes (e.g., workers of pytest-xdist and subprocesses of
+	// tests) that were still alive when pytest exited or timed out, and that
+	// had to be terminated.
+	ReapedProcesses int32 `protobuf:"varint,6,opt,name=reaped_processes,json=reapedProcesses,proto3" json:"reaped_processes,omitempty"`
+	// Tracebacks of all the threads of Python processes, which are dumped when
+	// the test times out (e.g., "Process 123:\nCurrent thread ...").
+	Tracebacks string `protobuf:"bytes,7,opt,name=tracebacks,proto3" json:"tracebacks,omitempty"`
+	// Maximum resident set size in bytes of the test including child processes
+	// that it waited for (e.g., workers of pytest-xdist).  The usage below also
+	// includes the child processes.  They are 0 if unknown.
+	MaxRss int64 `protobuf:"varint,8,opt,name=max_rss,json=maxRss,proto3" json:"max_rss,omitempty"`
+	// CPU time in seconds spent in user mode.
+	UserTime float32 `protobuf:"fixed32,9,opt,name=user_time,json=userTime,proto3" json:"user_time,omitempty"`
+	// CPU time in seconds spent in kernel mode.
+	SystemTime float32 `protobuf:"fixed32,10,opt,name=system_time,json=systemTime,proto3" json:"system_time,omitempty"`
+	// # of voluntary context switches (e.g., waiting for I/O).
+	VoluntaryContextSwitches int64 `protobuf:"varint,11,opt,name=voluntary_context_switches,json=voluntaryContextSwitches,proto3" json:"voluntary_context_switches,omitempty"`
+	// # of involuntary context switches (e.g., preempted by other processes).
+	InvoluntaryContextSwitches int64 `protobuf:"varint,12,opt,name=involuntary_context_switches,json=involuntaryContextSwitches,proto3" json:"involuntary_context_switches,omitempty"`
+	// Limit that the test exceeded if the status is LIMIT_EXCEEDED (e.g.,
+	// "memory limit of 1024 MB").
+	ExceededLimit string `protobuf:"bytes,13,opt,name=exceeded_limit,json=exceededLimit,proto3" json:"exceeded_limit,omitempty"`
+	// # of times that the test ran including retries (0 if unknown).
+	Trials int32 `protobuf:"varint,14,opt,name=trials,proto3" json:"trials,omitempty"`
+	// Node IDs of test cases that failed in the first trial if they are known.
+	FailedCases []string `protobuf:"bytes,15,rep,name=failed_cases,json=failedCases,proto3" json:"failed_cases,omitempty"`
+	// Node IDs of test cases that failed in the first trial and passed in a
+	// retry if the test is FLAKY.
+	FlakyCases           []string `protobuf:"bytes,16,rep,name=flaky_cases,json=flakyCases,proto3" json:"flaky_cases,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -157,7 +328,7 @@ func (m *TestResult) Reset()         { *m = TestResult{} }
 func (m *TestResult) String() string { return proto.CompactTextString(m) }
 func (*TestResult) ProtoMessage()    {}
 func (*TestResult) Descriptor() ([]byte, []int) {
-	return fileDescriptor_test_case_044fbc67b1ebf751, []int{1}
+	return fileDescriptor_test_case_76550c12846a8a99, []int{1}
 }
 func (m *TestResult) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_TestResult.Unmarshal(m, b)
@@ -212,23 +383,109 @@ func (m *TestResult) GetTime() float32 {
 	return 0
 }
 
+func (m *TestResult) GetReapedProcesses() int32 {
+	if m != nil {
+		return m.ReapedProcesses
+	}
+	return 0
+}
+
+func (m *TestResult) GetTracebacks() string {
+	if m != nil {
+		return m.Tracebacks
+	}
+	return ""
+}
+
+func (m *TestResult) GetMaxRss() int64 {
+	if m != nil {
+		return m.MaxRss
+	}
+	return 0
+}
+
+func (m *TestResult) GetUserTime() float32 {
+	if m != nil {
+		return m.UserTime
+	}
+	return 0
+}
+
+func (m *TestResult) GetSystemTime() float32 {
+	if m != nil {
+		return m.SystemTime
+	}
+	return 0
+}
+
+func (m *TestResult) GetVoluntaryContextSwitches() int64 {
+	if m != nil {
+		return m.VoluntaryContextSwitches
+	}
+	return 0
+}
+
+func (m *TestResult) GetInvoluntaryContextSwitches() int64 {
+	if m != nil {
+		return m.InvoluntaryContextSwitches
+	}
+	return 0
+}
+
+func (m *TestResult) GetExceededLimit() string {
+	if m != nil {
+		return m.ExceededLimit
+	}
+	return ""
+}
+
+func (m *TestResult) GetTrials() int32 {
+	if m != nil {
+		return m.Trials
+	}
+	return 0
+}
+
+func (m *TestResult) GetFailedCases() []string {
+	if m != nil {
+		return m.FailedCases
+	}
+	return nil
+}
+
+func (m *TestResult) GetFlakyCases() []string {
+	if m != nil {
+		return m.FlakyCases
+	}
+	return nil
+}
+
 type HintFile struct {
 	// TODO(imos): Deprecate this once it is confirmed that no one uses this.
 	SlowTests []*HintFile_Rule `protobuf:"bytes,1,rep,name=slow_tests,json=slowTests,proto3" json:"slow_tests,omitempty"`
 	// A list of rules.  If multiple rules matches a test target, a former rule
 	// will override a latter rule.  Test targets that no rule matches should be
 	// deprioritized.
-	Rules                []*HintFile_Rule `protobuf:"bytes,2,rep,name=rules,proto3" json:"rules,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
-	XXX_unrecognized     []byte           `json:"-"`
-	XXX_sizecache        int32            `json:"-"`
+	Rules []*HintFile_Rule `protobuf:"bytes,2,rep,name=rules,proto3" json:"rules,omitempty"`
+	// A list of quarantines.  If multiple quarantines match a test target, the
+	// former one is used.
+	Quarantines []*HintFile_Quarantine `protobuf:"bytes,3,rep,name=quarantines,proto3" json:"quarantines,omitempty"`
+	// A list of mappings from source files to tests, which select tests
+	// affected by changed files (e.g., for --changed_since).  Changed files
+	// that no mapping matches are mapped to tests named after them (e.g.,
+	// "foo/bar.py" to "test_bar.py") if they are Python files.  Documents
+	// (e.g., "README.md") affect no tests, and other files affect all tests.
+	Mappings             []*HintFile_Mapping `protobuf:"bytes,4,rep,name=mappings,proto3" json:"mappings,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
 }
 
 func (m *HintFile) Reset()         { *m = HintFile{} }
 func (m *HintFile) String() string { return proto.CompactTextString(m) }
 func (*HintFile) ProtoMessage()    {}
 func (*HintFile) Descriptor() ([]byte, []int) {
-	return fileDescriptor_test_case_044fbc67b1ebf751, []int{2}
+	return fileDescriptor_test_case_76550c12846a8a99, []int{2}
 }
 func (m *HintFile) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_HintFile.Unmarshal(m, b)
@@ -262,6 +519,20 @@ func (m *HintFile) GetRules() []*HintFile_Rule {
 	return nil
 }
 
+func (m *HintFile) GetQuarantines() []*HintFile_Quarantine {
+	if m != nil {
+		return m.Quarantines
+	}
+	return nil
+}
+
+func (m *HintFile) GetMappings() []*HintFile_Mapping {
+	if m != nil {
+		return m.Mappings
+	}
+	return nil
+}
+
 type HintFile_Rule struct {
 	// File name of a slow test (e.g.,"test_foo.py", "bar/test_foo.py").  Parent
 	// directories can be omitted (i.e., "test_foo.py" can matches
@@ -274,7 +545,25 @@ type HintFile_Rule struct {
 	// # of retries.  For flaky tests.
 	Retry int32 `protobuf:"varint,4,opt,name=retry,proto3" json:"retry,omitempty"`
 	// Resource usage multiplier (default: 1.0).
-	Resource             float32  `protobuf:"fixed32,5,opt,name=resource,proto3" json:"resource,omitempty"`
+	Resource float32 `protobuf:"fixed32,5,opt,name=resource,proto3" json:"resource,omitempty"`
+	// # of chunks to split test cases in the file into.  For large tests.
+	Chunks int32 `protobuf:"varint,6,opt,name=chunks,proto3" json:"chunks,omitempty"`
+	// Memory in MB that the test uses in a bucket.  For memory-hungry tests.
+	MemoryMb int32 `protobuf:"varint,7,opt,name=memory_mb,json=memoryMb,proto3" json:"memory_mb,omitempty"`
+	// # of device units that the test uses in a bucket.
+	DeviceUnits int32 `protobuf:"varint,8,opt,name=device_units,json=deviceUnits,proto3" json:"device_units,omitempty"`
+	// # of buckets that the test uses at once.  For multi-GPU tests.
+	Buckets int32 `protobuf:"varint,9,opt,name=buckets,proto3" json:"buckets,omitempty"`
+	// Names of locks that the test holds while it runs.  For tests sharing
+	// external state, which must not run concurrently.
+	Locks []string `protobuf:"bytes,10,rep,name=locks,proto3" json:"locks,omitempty"`
+	// Idle timeout in seconds.  For tests that can hang.
+	IdleTimeout float32 `protobuf:"fixed32,11,opt,name=idle_timeout,json=idleTimeout,proto3" json:"idle_timeout,omitempty"`
+	// Limit of the address space in MB of each process.  For tests that can
+	// allocate too much memory.
+	MemoryLimitMb int32 `protobuf:"varint,12,opt,name=memory_limit_mb,json=memoryLimitMb,proto3" json:"memory_limit_mb,omitempty"`
+	// Limit of CPU time in seconds of each process.  For tests that can spin.
+	CpuTimeLimit         float32  `protobuf:"fixed32,13,opt,name=cpu_time_limit,json=cpuTimeLimit,proto3" json:"cpu_time_limit,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -284,7 +573,7 @@ func (m *HintFile_Rule) Reset()         { *m = HintFile_Rule{} }
 func (m *HintFile_Rule) String() string { return proto.CompactTextString(m) }
 func (*HintFile_Rule) ProtoMessage()    {}
 func (*HintFile_Rule) Descriptor() ([]byte, []int) {
-	return fileDescriptor_test_case_044fbc67b1ebf751, []int{2, 0}
+	return fileDescriptor_test_case_76550c12846a8a99, []int{2, 0}
 }
 func (m *HintFile_Rule) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_HintFile_Rule.Unmarshal(m, b)
@@ -339,44 +628,361 @@ func (m *HintFile_Rule) GetResource() float32 {
 	return 0
 }
 
+func (m *HintFile_Rule) GetChunks() int32 {
+	if m != nil {
+		return m.Chunks
+	}
+	return 0
+}
+
+func (m *HintFile_Rule) GetMemoryMb() int32 {
+	if m != nil {
+		return m.MemoryMb
+	}
+	return 0
+}
+
+func (m *HintFile_Rule) GetDeviceUnits() int32 {
+	if m != nil {
+		return m.DeviceUnits
+	}
+	return 0
+}
+
+func (m *HintFile_Rule) GetBuckets() int32 {
+	if m != nil {
+		return m.Buckets
+	}
+	return 0
+}
+
+func (m *HintFile_Rule) GetLocks() []string {
+	if m != nil {
+		return m.Locks
+	}
+	return nil
+}
+
+func (m *HintFile_Rule) GetIdleTimeout() float32 {
+	if m != nil {
+		return m.IdleTimeout
+	}
+	return 0
+}
+
+func (m *HintFile_Rule) GetMemoryLimitMb() int32 {
+	if m != nil {
+		return m.MemoryLimitMb
+	}
+	return 0
+}
+
+func (m *HintFile_Rule) GetCpuTimeLimit() float32 {
+	if m != nil {
+		return m.CpuTimeLimit
+	}
+	return 0
+}
+
+type HintFile_Quarantine struct {
+	// File name or glob pattern of quarantined tests (e.g., "test_foo.py",
+	// "tests/foo_tests/**/test_*.py").  Parent directories can be omitted as
+	// Rule.name.
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Reason why the tests are quarantined (e.g., an issue URL).
+	Reason string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	// Owner who is responsible for fixing the tests.
+	Owner string `protobuf:"bytes,3,opt,name=owner,proto3" json:"owner,omitempty"`
+	// Expiry date in the form of "YYYY-MM-DD".  A warning is shown once the
+	// date passes.  No expiry if this is empty.
+	Expiry               string   `protobuf:"bytes,4,opt,name=expiry,proto3" json:"expiry,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HintFile_Quarantine) Reset()         { *m = HintFile_Quarantine{} }
+func (m *HintFile_Quarantine) String() string { return proto.CompactTextString(m) }
+func (*HintFile_Quarantine) ProtoMessage()    {}
+func (*HintFile_Quarantine) Descriptor() ([]byte, []int) {
+	return fileDescriptor_test_case_76550c12846a8a99, []int{2, 1}
+}
+func (m *HintFile_Quarantine) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HintFile_Quarantine.Unmarshal(m, b)
+}
+func (m *HintFile_Quarantine) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HintFile_Quarantine.Marshal(b, m, deterministic)
+}
+func (dst *HintFile_Quarantine) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HintFile_Quarantine.Merge(dst, src)
+}
+func (m *HintFile_Quarantine) XXX_Size() int {
+	return xxx_messageInfo_HintFile_Quarantine.Size(m)
+}
+func (m *HintFile_Quarantine) XXX_DiscardUnknown() {
+	xxx_messageInfo_HintFile_Quarantine.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HintFile_Quarantine proto.InternalMessageInfo
+
+func (m *HintFile_Quarantine) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *HintFile_Quarantine) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+func (m *HintFile_Quarantine) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+func (m *HintFile_Quarantine) GetExpiry() string {
+	if m != nil {
+		return m.Expiry
+	}
+	return ""
+}
+
+type HintFile_Mapping struct {
+	// File name or glob pattern of source files (e.g., "foo/bar.py",
+	// "foo/**/*.cu").  Parent directories can be omitted as Rule.name.
+	Source string `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	// File names or glob patterns of tests affected by the source files
+	// (e.g., "tests/foo_tests/**/test_*.py").  If empty, the source files
+	// affect no tests.
+	Tests                []string `protobuf:"bytes,2,rep,name=tests,proto3" json:"tests,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HintFile_Mapping) Reset()         { *m = HintFile_Mapping{} }
+func (m *HintFile_Mapping) String() string { return proto.CompactTextString(m) }
+func (*HintFile_Mapping) ProtoMessage()    {}
+func (*HintFile_Mapping) Descriptor() ([]byte, []int) {
+	return fileDescriptor_test_case_76550c12846a8a99, []int{2, 2}
+}
+func (m *HintFile_Mapping) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HintFile_Mapping.Unmarshal(m, b)
+}
+func (m *HintFile_Mapping) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HintFile_Mapping.Marshal(b, m, deterministic)
+}
+func (dst *HintFile_Mapping) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HintFile_Mapping.Merge(dst, src)
+}
+func (m *HintFile_Mapping) XXX_Size() int {
+	return xxx_messageInfo_HintFile_Mapping.Size(m)
+}
+func (m *HintFile_Mapping) XXX_DiscardUnknown() {
+	xxx_messageInfo_HintFile_Mapping.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HintFile_Mapping proto.InternalMessageInfo
+
+func (m *HintFile_Mapping) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+func (m *HintFile_Mapping) GetTests() []string {
+	if m != nil {
+		return m.Tests
+	}
+	return nil
+}
+
+type HistoryFile struct {
+	Entries              []*HistoryFile_Entry `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *HistoryFile) Reset()         { *m = HistoryFile{} }
+func (m *HistoryFile) String() string { return proto.CompactTextString(m) }
+func (*HistoryFile) ProtoMessage()    {}
+func (*HistoryFile) Descriptor() ([]byte, []int) {
+	return fileDescriptor_test_case_76550c12846a8a99, []int{3}
+}
+func (m *HistoryFile) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HistoryFile.Unmarshal(m, b)
+}
+func (m *HistoryFile) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HistoryFile.Marshal(b, m, deterministic)
+}
+func (dst *HistoryFile) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HistoryFile.Merge(dst, src)
+}
+func (m *HistoryFile) XXX_Size() int {
+	return xxx_messageInfo_HistoryFile.Size(m)
+}
+func (m *HistoryFile) XXX_DiscardUnknown() {
+	xxx_messageInfo_HistoryFile.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HistoryFile proto.InternalMessageInfo
+
+func (m *HistoryFile) GetEntries() []*HistoryFile_Entry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+type HistoryFile_Entry struct {
+	// Test name (e.g., "tests/foo_tests/test_bar.py").
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Smoothed duration in seconds that the test took in previous runs (0 if
+	// no run finished normally).
+	Time float32 `protobuf:"fixed32,2,opt,name=time,proto3" json:"time,omitempty"`
+	// Maximum resident set size in bytes that the test used in the latest
+	// run (0 if unknown).
+	MaxRss               int64    `protobuf:"varint,3,opt,name=max_rss,json=maxRss,proto3" json:"max_rss,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HistoryFile_Entry) Reset()         { *m = HistoryFile_Entry{} }
+func (m *HistoryFile_Entry) String() string { return proto.CompactTextString(m) }
+func (*HistoryFile_Entry) ProtoMessage()    {}
+func (*HistoryFile_Entry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_test_case_76550c12846a8a99, []int{3, 0}
+}
+func (m *HistoryFile_Entry) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HistoryFile_Entry.Unmarshal(m, b)
+}
+func (m *HistoryFile_Entry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HistoryFile_Entry.Marshal(b, m, deterministic)
+}
+func (dst *HistoryFile_Entry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HistoryFile_Entry.Merge(dst, src)
+}
+func (m *HistoryFile_Entry) XXX_Size() int {
+	return xxx_messageInfo_HistoryFile_Entry.Size(m)
+}
+func (m *HistoryFile_Entry) XXX_DiscardUnknown() {
+	xxx_messageInfo_HistoryFile_Entry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HistoryFile_Entry proto.InternalMessageInfo
+
+func (m *HistoryFile_Entry) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *HistoryFile_Entry) GetTime() float32 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
+func (m *HistoryFile_Entry) GetMaxRss() int64 {
+	if m != nil {
+		return m.MaxRss
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*TestQuery)(nil), "xpytest.proto.TestQuery")
 	proto.RegisterType((*TestResult)(nil), "xpytest.proto.TestResult")
 	proto.RegisterType((*HintFile)(nil), "xpytest.proto.HintFile")
 	proto.RegisterType((*HintFile_Rule)(nil), "xpytest.proto.HintFile.Rule")
+	proto.RegisterType((*HintFile_Quarantine)(nil), "xpytest.proto.HintFile.Quarantine")
+	proto.RegisterType((*HintFile_Mapping)(nil), "xpytest.proto.HintFile.Mapping")
+	proto.RegisterType((*HistoryFile)(nil), "xpytest.proto.HistoryFile")
+	proto.RegisterType((*HistoryFile_Entry)(nil), "xpytest.proto.HistoryFile.Entry")
 	proto.RegisterEnum("xpytest.proto.TestResult_Status", TestResult_Status_name, TestResult_Status_value)
 }
 
 func init() {
-	proto.RegisterFile("xpytest/proto/test_case.proto", fileDescriptor_test_case_044fbc67b1ebf751)
-}
-
-var fileDescriptor_test_case_044fbc67b1ebf751 = []byte{
-	// 407 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x51, 0x4d, 0x8b, 0xd4, 0x40,
-	0x10, 0xb5, 0xf3, 0xe5, 0xa4, 0x56, 0x25, 0x34, 0x22, 0x61, 0x51, 0x08, 0x39, 0xe5, 0x94, 0x85,
-	0xf1, 0x22, 0x78, 0x1a, 0xd6, 0x0c, 0x0e, 0x3b, 0x66, 0xb1, 0x93, 0x41, 0x3c, 0x2d, 0x71, 0x53,
-	0x42, 0x20, 0x3b, 0x19, 0xba, 0x3b, 0xb8, 0xb9, 0xf8, 0x57, 0xfc, 0x93, 0xde, 0xbc, 0x48, 0x75,
-	0xb2, 0xe3, 0x8c, 0x38, 0xe0, 0xad, 0x5e, 0xf5, 0xab, 0xea, 0xf7, 0xea, 0xc1, 0xab, 0xfb, 0xdd,
-	0xa0, 0x51, 0xe9, 0x8b, 0x9d, 0xec, 0x74, 0x77, 0x41, 0xe5, 0xcd, 0x6d, 0xa5, 0x30, 0x35, 0x98,
-	0x3f, 0x9d, 0x9e, 0x47, 0x18, 0xff, 0x60, 0xe0, 0x97, 0xa8, 0xf4, 0xc7, 0x1e, 0xe5, 0xc0, 0x39,
-	0x38, 0x5f, 0x9b, 0x16, 0x43, 0x16, 0xb1, 0xc4, 0x17, 0xa6, 0xe6, 0xe7, 0x30, 0xdb, 0xc9, 0xa6,
-	0x93, 0x8d, 0x1e, 0x42, 0x2b, 0x62, 0x89, 0x2b, 0xf6, 0x98, 0xde, 0x6a, 0xac, 0xea, 0xb6, 0xd9,
-	0x62, 0x68, 0x47, 0x2c, 0xb1, 0xc4, 0x1e, 0xf3, 0xe7, 0xe0, 0xde, 0xd7, 0x8d, 0xd2, 0xa1, 0x63,
-	0x86, 0x46, 0x40, 0x5d, 0x89, 0x5a, 0x0e, 0xa1, 0x3b, 0x76, 0x0d, 0xa0, 0x3d, 0x12, 0x55, 0xd7,
-	0xcb, 0x5b, 0x0c, 0xbd, 0x71, 0xcf, 0x03, 0x8e, 0x7f, 0x32, 0x00, 0x52, 0x28, 0x50, 0xf5, 0xad,
-	0xe6, 0x6f, 0xc0, 0x53, 0xba, 0xd2, 0xbd, 0x32, 0x22, 0x9f, 0xcd, 0xa3, 0xf4, 0xc8, 0x50, 0xfa,
-	0x87, 0x9a, 0x16, 0x86, 0x27, 0x26, 0x3e, 0x99, 0xdb, 0x56, 0x77, 0x68, 0x4c, 0xf8, 0xc2, 0xd4,
-	0xfc, 0x05, 0x6d, 0xab, 0xbb, 0x5e, 0x1b, 0xf9, 0xbe, 0x98, 0xd0, 0xd4, 0x47, 0x29, 0x8d, 0xfa,
-	0xb1, 0x8f, 0x52, 0xd2, 0x0e, 0xdd, 0xdc, 0xa1, 0x51, 0x6f, 0x09, 0x53, 0xc7, 0x25, 0x78, 0xe3,
-	0x4f, 0xfc, 0x0c, 0x1e, 0x6f, 0xf2, 0xab, 0xfc, 0xfa, 0x53, 0x1e, 0x3c, 0x22, 0x50, 0x6c, 0x2e,
-	0x2f, 0xb3, 0xa2, 0x08, 0x18, 0x7f, 0x02, 0xb3, 0x55, 0x5e, 0x66, 0x22, 0x5f, 0xac, 0x03, 0x8b,
-	0x03, 0x78, 0xcb, 0xc5, 0x6a, 0x9d, 0xbd, 0x0b, 0x6c, 0xa2, 0x95, 0xab, 0x0f, 0xd9, 0xf5, 0xa6,
-	0x0c, 0x1c, 0xee, 0x83, 0xbb, 0x5c, 0x2f, 0xae, 0x3e, 0x07, 0x6e, 0xfc, 0x8b, 0xc1, 0xec, 0x7d,
-	0xb3, 0xd5, 0x4b, 0xca, 0xe0, 0x2d, 0x80, 0x6a, 0xbb, 0x6f, 0x37, 0xe4, 0x93, 0x8c, 0xdb, 0xc9,
-	0xd9, 0xfc, 0xe5, 0x5f, 0xc6, 0x1f, 0xc8, 0xa9, 0xe8, 0x5b, 0x14, 0x3e, 0xf1, 0xe9, 0x16, 0x8a,
-	0xcf, 0xc1, 0x95, 0x7d, 0x8b, 0x2a, 0xb4, 0xfe, 0x63, 0x6e, 0xa4, 0x9e, 0x7f, 0x07, 0x87, 0xe0,
-	0xfe, 0x66, 0xec, 0xe0, 0x66, 0x87, 0xa1, 0x5b, 0xa7, 0x42, 0xb7, 0xff, 0x19, 0xba, 0x73, 0x2a,
-	0x74, 0xf7, 0x38, 0xf4, 0x2f, 0x9e, 0xd1, 0xf6, 0xfa, 0x77, 0x00, 0x00, 0x00, 0xff, 0xff, 0x1e,
-	0x55, 0x16, 0xfe, 0xcd, 0x02, 0x00, 0x00,
+	proto.RegisterFile("xpytest/proto/test_case.proto", fileDescriptor_test_case_76550c12846a8a99)
+}
+
+var fileDescriptor_test_case_76550c12846a8a99 = []byte{
+	// 1049 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x54, 0x5f, 0x6f, 0xe3, 0xc4,
+	0x17, 0xfd, 0xe5, 0x9f, 0x13, 0x5f, 0x27, 0xad, 0x77, 0xf4, 0xd3, 0x32, 0x84, 0x85, 0x0d, 0x11,
+	0xa0, 0x20, 0xa4, 0xac, 0x54, 0x1e, 0x40, 0x2c, 0x0f, 0x94, 0x26, 0xa5, 0xd1, 0xb6, 0xe9, 0xee,
+	0x34, 0x11, 0xf0, 0x64, 0x39, 0xf6, 0xed, 0xee, 0xa8, 0x8e, 0x1d, 0x66, 0xc6, 0xdb, 0xe4, 0x5b,
+	0x20, 0xf1, 0xc4, 0x37, 0xe1, 0x93, 0xf0, 0x55, 0x78, 0x45, 0x33, 0xe3, 0xa4, 0x69, 0xb7, 0x59,
+	0xfa, 0x36, 0xe7, 0xcc, 0xb9, 0xd7, 0xbe, 0x33, 0x67, 0x0e, 0x7c, 0xbc, 0x5c, 0xac, 0x14, 0x4a,
+	0xf5, 0x6c, 0x21, 0x32, 0x95, 0x3d, 0xd3, 0xcb, 0x20, 0x0a, 0x25, 0xf6, 0x0d, 0x26, 0xad, 0x62,
+	0xdb, 0xc2, 0xee, 0x5f, 0x55, 0x70, 0x27, 0x28, 0xd5, 0xab, 0x1c, 0xc5, 0x8a, 0x10, 0xa8, 0x5e,
+	0xf2, 0x04, 0x69, 0xa9, 0x53, 0xea, 0xb9, 0xcc, 0xac, 0x49, 0x1b, 0x1a, 0x0b, 0xc1, 0x33, 0xc1,
+	0xd5, 0x8a, 0x96, 0x3b, 0xa5, 0x5e, 0x8d, 0x6d, 0xb0, 0xde, 0x8b, 0x31, 0x8c, 0x13, 0x9e, 0x22,
+	0xad, 0x74, 0x4a, 0xbd, 0x32, 0xdb, 0x60, 0xf2, 0x7f, 0xa8, 0x2d, 0x63, 0x2e, 0x15, 0xad, 0x9a,
+	0x22, 0x0b, 0x34, 0x2b, 0x50, 0x89, 0x15, 0xad, 0x59, 0xd6, 0x00, 0xdd, 0x47, 0xa0, 0xcc, 0x72,
+	0x11, 0x21, 0x75, 0x6c, 0x9f, 0x35, 0x26, 0x5f, 0xc1, 0x23, 0x5c, 0x2e, 0x30, 0x52, 0x18, 0x07,
+	0x71, 0x2e, 0x42, 0xc5, 0xb3, 0x94, 0xd6, 0x8d, 0xc8, 0x5f, 0x6f, 0x0c, 0x0a, 0x9e, 0x3c, 0x06,
+	0x27, 0x7a, 0x93, 0xa7, 0x57, 0x92, 0x36, 0x4c, 0xff, 0x02, 0x91, 0x0f, 0xa1, 0x91, 0x66, 0x31,
+	0x06, 0x3c, 0x96, 0xd4, 0xed, 0x54, 0x7a, 0x2e, 0xab, 0x6b, 0x3c, 0x8a, 0x25, 0xf9, 0x08, 0xdc,
+	0x39, 0xce, 0x33, 0xb1, 0x0a, 0xe6, 0x33, 0x0a, 0x76, 0x40, 0x4b, 0x9c, 0xcd, 0xc8, 0xa7, 0xd0,
+	0x8c, 0xf1, 0x2d, 0x8f, 0x30, 0xc8, 0x53, 0xae, 0x24, 0xf5, 0xcc, 0xbe, 0x67, 0xb9, 0xa9, 0xa6,
+	0x08, 0x85, 0xfa, 0x2c, 0x8f, 0xae, 0x50, 0x49, 0xda, 0x34, 0xbb, 0x6b, 0xa8, 0x67, 0x4d, 0xb2,
+	0xe8, 0x4a, 0xd2, 0x96, 0xf9, 0xa2, 0x05, 0xe4, 0x47, 0x80, 0xdf, 0xf2, 0x50, 0x84, 0xa9, 0xd2,
+	0xa7, 0xb6, 0xd7, 0x29, 0xf5, 0xbc, 0x83, 0x6e, 0xff, 0xd6, 0xad, 0xf4, 0x4f, 0x78, 0xaa, 0x8e,
+	0x79, 0x82, 0xfd, 0x57, 0x1b, 0x25, 0xdb, 0xaa, 0xd2, 0xbf, 0xc5, 0xe3, 0x04, 0x03, 0xc5, 0xe7,
+	0x98, 0xe5, 0x8a, 0xee, 0x9b, 0xe3, 0xf0, 0x34, 0x37, 0xb1, 0x14, 0xf9, 0x02, 0xf6, 0x8b, 0xb1,
+	0x12, 0x3e, 0xe7, 0x4a, 0x0f, 0xe7, 0x9b, 0xdf, 0x6b, 0x59, 0xfa, 0x54, 0xb3, 0x67, 0x33, 0xf2,
+	0x19, 0xec, 0x45, 0x8b, 0xdc, 0x74, 0xb2, 0x4a, 0xfa, 0xc8, 0x34, 0x6b, 0x46, 0x8b, 0x5c, 0xf7,
+	0x32, 0x3a, 0x6d, 0x8c, 0x50, 0xbc, 0x96, 0x94, 0x98, 0x49, 0xcc, 0xba, 0xfb, 0x77, 0x0d, 0x40,
+	0x5b, 0x87, 0xa1, 0xcc, 0x13, 0x45, 0xbe, 0x05, 0x47, 0xaa, 0x50, 0xe5, 0xd2, 0xb8, 0x67, 0xef,
+	0xa0, 0x73, 0x67, 0xa6, 0x1b, 0x69, 0xff, 0xc2, 0xe8, 0x58, 0xa1, 0xd7, 0xcd, 0xd3, 0x70, 0x8e,
+	0xc6, 0x5d, 0x2e, 0x33, 0x6b, 0x7d, 0x91, 0x52, 0xc5, 0x7a, 0xb6, 0x8a, 0x61, 0x0b, 0x54, 0xf0,
+	0x28, 0x04, 0xad, 0x6e, 0x78, 0x14, 0x42, 0xf7, 0xd0, 0x23, 0x18, 0x5b, 0x95, 0x99, 0x59, 0x93,
+	0x2f, 0xc1, 0x17, 0x18, 0x2e, 0x30, 0x0e, 0x16, 0x22, 0x8b, 0x50, 0x4a, 0x94, 0xc6, 0x5d, 0x35,
+	0xb6, 0x6f, 0xf9, 0x97, 0x6b, 0x9a, 0x7c, 0x02, 0xa0, 0x44, 0x18, 0xe1, 0x2c, 0xd4, 0xf7, 0x55,
+	0x37, 0xad, 0xb7, 0x18, 0xf2, 0x01, 0xd4, 0xe7, 0xe1, 0x32, 0x10, 0xd2, 0x1a, 0xab, 0xc2, 0x9c,
+	0x79, 0xb8, 0x64, 0xd2, 0xb8, 0x27, 0x97, 0x28, 0xcc, 0xf9, 0x51, 0xd7, 0x5a, 0x57, 0x13, 0xfa,
+	0xe8, 0xc8, 0x53, 0xf0, 0xe4, 0x4a, 0x2a, 0x9c, 0xdb, 0x6d, 0x30, 0xdb, 0x60, 0x29, 0x23, 0xf8,
+	0x1e, 0xda, 0x6f, 0xb3, 0x24, 0x4f, 0x55, 0x28, 0x56, 0x41, 0x94, 0xa5, 0x0a, 0x97, 0x2a, 0x90,
+	0xd7, 0x5c, 0x45, 0x6f, 0xd0, 0x9a, 0xad, 0xc2, 0xe8, 0x46, 0x71, 0x64, 0x05, 0x17, 0xc5, 0x3e,
+	0xf9, 0x01, 0x9e, 0xf0, 0xf4, 0x3d, 0xf5, 0x4d, 0x53, 0xdf, 0xe6, 0xe9, 0xce, 0x0e, 0x9f, 0xc3,
+	0x1e, 0x2e, 0x23, 0xc4, 0x18, 0xe3, 0xe2, 0xf2, 0x5b, 0x66, 0xf4, 0xd6, 0x9a, 0xb5, 0xb7, 0xff,
+	0x18, 0x1c, 0x25, 0x78, 0x98, 0x48, 0x63, 0xd7, 0x1a, 0x2b, 0x90, 0xb6, 0xe1, 0x65, 0xc8, 0x13,
+	0x8c, 0x4d, 0xc0, 0x48, 0xba, 0x6f, 0xdc, 0xe1, 0x59, 0xee, 0x48, 0x53, 0xfa, 0x08, 0x2e, 0x93,
+	0xf0, 0x6a, 0x55, 0x28, 0x7c, 0xa3, 0x00, 0x43, 0x19, 0x41, 0xf7, 0xf7, 0x12, 0x38, 0xd6, 0x0f,
+	0xc4, 0x83, 0xfa, 0x74, 0xfc, 0x62, 0x7c, 0xfe, 0xf3, 0xd8, 0xff, 0x9f, 0x06, 0x17, 0xd3, 0xa3,
+	0xa3, 0xe1, 0xc5, 0x85, 0x5f, 0x22, 0x4d, 0x68, 0x8c, 0xc6, 0x93, 0x21, 0x1b, 0x1f, 0x9e, 0xfa,
+	0x65, 0x02, 0xe0, 0x1c, 0x1f, 0x8e, 0x4e, 0x87, 0x03, 0xbf, 0xa2, 0x65, 0x93, 0xd1, 0xd9, 0xf0,
+	0x7c, 0x3a, 0xf1, 0xab, 0xc4, 0x85, 0xda, 0xf1, 0xe9, 0xe1, 0x8b, 0x5f, 0xfd, 0x9a, 0xe6, 0xc7,
+	0xe7, 0x93, 0x80, 0x4d, 0xc7, 0xbe, 0x43, 0xf6, 0xc1, 0x33, 0xe5, 0x6c, 0xfa, 0x72, 0x32, 0x1c,
+	0xf8, 0x75, 0xd2, 0x80, 0xea, 0xc9, 0x74, 0xfc, 0x93, 0xdf, 0x20, 0x04, 0xf6, 0x4e, 0x47, 0x67,
+	0xa3, 0x49, 0x30, 0xfc, 0xe5, 0x68, 0x38, 0x1c, 0x0c, 0x07, 0xbe, 0xdb, 0xfd, 0xd3, 0x81, 0xc6,
+	0xfa, 0x05, 0x92, 0xe7, 0x00, 0x32, 0xc9, 0xae, 0x03, 0xed, 0x64, 0x6d, 0xed, 0x4a, 0xcf, 0x3b,
+	0x78, 0xb2, 0xeb, 0xb9, 0xb2, 0x3c, 0x41, 0xe6, 0x6a, 0xbd, 0x76, 0xbb, 0x24, 0x07, 0x50, 0x13,
+	0x79, 0x82, 0x92, 0x96, 0x1f, 0x50, 0x67, 0xa5, 0x64, 0x00, 0xde, 0xcd, 0x4b, 0x97, 0xb4, 0xd2,
+	0xa9, 0x3c, 0x30, 0x20, 0xb6, 0xcb, 0xc8, 0x73, 0x68, 0xcc, 0xc3, 0xc5, 0x82, 0xa7, 0xaf, 0x25,
+	0xad, 0x9a, 0x16, 0x4f, 0x77, 0xb5, 0x38, 0xb3, 0x3a, 0xb6, 0x29, 0x68, 0xff, 0x53, 0x86, 0xaa,
+	0xfe, 0xa5, 0xcd, 0xcb, 0x2c, 0x6d, 0xbd, 0xcc, 0xed, 0xcc, 0x2f, 0xef, 0xca, 0xfc, 0xca, 0xbd,
+	0x99, 0x5f, 0xdd, 0x95, 0xf9, 0xb5, 0x3b, 0x99, 0x7f, 0x13, 0xe3, 0xce, 0xad, 0x18, 0xbf, 0x95,
+	0xd5, 0xf5, 0xff, 0xc8, 0xea, 0xc6, 0x7b, 0xb3, 0xda, 0xdd, 0x91, 0xd5, 0xb0, 0x9d, 0xd5, 0x77,
+	0x73, 0xd6, 0x7b, 0x50, 0xce, 0x36, 0x1f, 0x96, 0xb3, 0xad, 0x77, 0x73, 0xb6, 0x7d, 0x09, 0x70,
+	0x73, 0xa3, 0xf7, 0x1e, 0xff, 0x63, 0x70, 0x04, 0x86, 0x32, 0x4b, 0x8b, 0xb8, 0x2c, 0x90, 0x1e,
+	0x20, 0xbb, 0x4e, 0x51, 0x14, 0x79, 0x69, 0x81, 0x56, 0xe3, 0x72, 0xc1, 0x8b, 0xb3, 0x77, 0x59,
+	0x81, 0xda, 0xdf, 0x40, 0xbd, 0xb8, 0x76, 0x2d, 0x29, 0x6e, 0xc1, 0x7e, 0xa6, 0x40, 0xba, 0xa1,
+	0xf5, 0x7c, 0xd9, 0x9e, 0x88, 0x01, 0xdd, 0x3f, 0x4a, 0xe0, 0x9d, 0x70, 0xa9, 0x32, 0xb1, 0x32,
+	0xcf, 0xe3, 0x3b, 0xa8, 0x63, 0xaa, 0x04, 0xc7, 0xf5, 0xdb, 0xe8, 0xbc, 0x63, 0xb3, 0x8d, 0xb8,
+	0x3f, 0x4c, 0x95, 0x58, 0xb1, 0x75, 0x41, 0xfb, 0x04, 0x6a, 0x86, 0xb9, 0x77, 0xce, 0x75, 0xa0,
+	0x97, 0xb7, 0x02, 0x7d, 0x2b, 0x85, 0x2b, 0xdb, 0x29, 0x3c, 0x73, 0xcc, 0xb7, 0xbe, 0xfe, 0x77,
+	0x00, 0x07, 0xf5, 0xfa, 0x97, 0xfc, 0x08, 0x00, 0x00,
 }